@@ -21,7 +21,7 @@ gosec .
 go test .
 CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go install -v .
 
-CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o main main.go
+CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o main .
 
 zip main.zip main
 
@@ -5,7 +5,7 @@ die () {
     exit 1
 }
 
-region=sa-east-1
+region=${AWS_REGION:-sa-east-1}
 role_name=role_config_lambda
 lambda_name=FunctionConfigLambda
 
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// baselineKey: reserved target key holding baseline directives.
+// It is removed from the target before comparison.
+const baselineKey = "$baseline"
+
+// Slice comparison modes
+const (
+	sliceOrdered = "ordered" // element by element, by index (default)
+	sliceSet     = "set"     // any order, item must not hold extra elements
+	sliceSubset  = "subset"  // any order, item may hold extra elements
+)
+
+// baselineRules: directives from the baseline document
+//
+//	"$baseline": {
+//	    "slices": {
+//	        "relationships": "set",
+//	        "configuration.securityGroups": "subset"
+//	    },
+//	    "keys": {
+//	        "configuration.networkInterfaces": "networkInterfaceId"
+//	    },
+//	    "ignore": [
+//	        "configurationItemCaptureTime",
+//	        "relationships[*].relationshipName"
+//	    ],
+//	    "absent": [
+//	        "configuration.networkInterfaces[*].association"
+//	    ]
+//	}
+//
+// Paths are patterns (see matchPath).
+type baselineRules struct {
+	Slices map[string]string `json:"slices,omitempty"` // path => slice comparison mode
+	Keys   map[string]string `json:"keys,omitempty"`   // path => identity field of slice elements
+	Ignore []string          `json:"ignore,omitempty"` // paths skipped by comparison
+	Absent []string          `json:"absent,omitempty"` // paths that must not exist in item
+
+	Captured *captureInfo `json:"captured,omitempty"` // origin of baseline created by capture, not used by comparison
+
+	ignore [][]string // split Ignore patterns
+	absent [][]string // split Absent patterns
+}
+
+// captureInfo: who created a captured baseline, and when
+type captureInfo struct {
+	By          string `json:"by"`          // rule and function capturing the item
+	At          string `json:"at"`          // capture time, RFC3339
+	CaptureTime string `json:"captureTime"` // configurationItemCaptureTime of captured item
+}
+
+// splitBaseline: extract directives from target document.
+// Returns the rules and the target without the reserved key.
+func splitBaseline(target map[string]interface{}) (baselineRules, map[string]interface{}, error) {
+	var rules baselineRules
+
+	directives, found := target[baselineKey]
+	if !found {
+		return rules, target, nil
+	}
+
+	clean := make(map[string]interface{}, len(target)-1)
+	for k, v := range target {
+		if k != baselineKey {
+			clean[k] = v
+		}
+	}
+
+	buf, errMarshal := json.Marshal(directives)
+	if errMarshal != nil {
+		return rules, clean, fmt.Errorf("%s: %v", baselineKey, errMarshal)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if errJson := dec.Decode(&rules); errJson != nil {
+		return rules, clean, fmt.Errorf("%s: %v", baselineKey, errJson)
+	}
+
+	for path, mode := range rules.Slices {
+		switch mode {
+		case sliceOrdered, sliceSet, sliceSubset:
+		default:
+			return rules, clean, fmt.Errorf("%s: slices: path=%s bad mode=%s (expected %s, %s or %s)", baselineKey, path, mode, sliceOrdered, sliceSet, sliceSubset)
+		}
+	}
+
+	for path, field := range rules.Keys {
+		if field == "" {
+			return rules, clean, fmt.Errorf("%s: keys: path=%s empty key field", baselineKey, path)
+		}
+	}
+
+	for _, p := range rules.Ignore {
+		if p == "" {
+			return rules, clean, fmt.Errorf("%s: ignore: empty path", baselineKey)
+		}
+		rules.ignore = append(rules.ignore, splitPath(p))
+	}
+
+	for _, p := range rules.Absent {
+		if p == "" {
+			return rules, clean, fmt.Errorf("%s: absent: empty path", baselineKey)
+		}
+		rules.absent = append(rules.absent, splitPath(p))
+	}
+
+	return rules, clean, nil
+}
+
+// lookupPath: value for first pattern matching path.
+// Exact match is preferred, then patterns are tried in sorted order.
+func lookupPath(m map[string]string, path string) (string, bool) {
+	if v, found := m[path]; found {
+		return v, true
+	}
+	if len(m) == 0 {
+		return "", false
+	}
+	patterns := make([]string, 0, len(m))
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	segments := splitPath(path)
+	for _, p := range patterns {
+		if matchPath(splitPath(p), segments) {
+			return m[p], true
+		}
+	}
+	return "", false
+}
+
+// sliceMode: comparison mode for slice found at path
+func (r baselineRules) sliceMode(path string) string {
+	if mode, found := lookupPath(r.Slices, path); found {
+		return mode
+	}
+	return sliceOrdered
+}
+
+// sliceKey: identity field for elements of slice found at path, if any
+func (r baselineRules) sliceKey(path string) string {
+	field, _ := lookupPath(r.Keys, path)
+	return field
+}
+
+// ignored: path is excluded from comparison
+func (r baselineRules) ignored(path string) bool {
+	if len(r.ignore) == 0 {
+		return false
+	}
+	segments := splitPath(path)
+	for _, p := range r.ignore {
+		if matchPath(p, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath: "a.b[0].c" => "a", "b", "[0]", "c".
+// Backslash escapes the next char, so keys holding '.' or '[' can be addressed:
+// "tags.kubernetes\.io/role" => "tags", "kubernetes.io/role" (see escapeKey).
+func splitPath(path string) []string {
+	var segments []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			segments = append(segments, b.String())
+			b.Reset()
+		}
+	}
+	for i := 0; i < len(path); i++ {
+		switch ch := path[i]; ch {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				b.WriteByte(path[i])
+			}
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				end = len(path) - i - 1
+			}
+			segments = append(segments, path[i:i+end+1])
+			i += end
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	flush()
+	return segments
+}
+
+// escapeKey: map key as path segment, escaping chars meaningful to splitPath
+func escapeKey(key string) string {
+	if !strings.ContainsAny(key, `\.[`) {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch ch := key[i]; ch {
+		case '\\', '.', '[':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+func isIndexSegment(s string) bool {
+	return strings.HasPrefix(s, "[")
+}
+
+// matchPath: match split path against split pattern.
+// Pattern segment "*" matches any map key.
+// Pattern segment "[*]" matches any slice element, either by index like [0]
+// or by identity like [networkInterfaceId=eni-0123].
+func matchPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, p := range pattern {
+		s := path[i]
+		switch {
+		case p == s:
+		case p == "*" && !isIndexSegment(s):
+		case p == "[*]" && isIndexSegment(s):
+		default:
+			return false
+		}
+	}
+	return true
+}
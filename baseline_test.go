@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPath(t *testing.T) {
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", ""},
+		{"a", "a"},
+		{"a.b", "a|b"},
+		{"a[0].b", "a|[0]|b"},
+		{"a[*][1]", "a|[*]|[1]"},
+		{"n[ip=10.0.0.1].v", "n|[ip=10.0.0.1]|v"},
+		{`tags.kubernetes\.io/cluster/x`, "tags|kubernetes.io/cluster/x"},
+		{`a\[0\].b`, "a[0]|b"},
+		{`a\\.b`, `a\|b`},
+	}
+
+	for _, test := range tests {
+		if result := strings.Join(splitPath(test.path), "|"); result != test.expected {
+			t.Errorf("path=%s expected=%s result=%s", test.path, test.expected, result)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.b", "a.b.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a[0]", false},
+		{"a[*].b", "a[3].b", true},
+		{"a[*].b", "a[id=x].b", true},
+		{"a[*].b", "a.x.b", false},
+		{"a[1].b", "a[0].b", false},
+		{`tags.kubernetes\.io/role`, pathKey("tags", "kubernetes.io/role"), true},
+		{"tags.*", pathKey("tags", "kubernetes.io/role"), true},
+		{"tags.kubernetes.io/role", pathKey("tags", "kubernetes.io/role"), false},
+	}
+
+	for _, test := range tests {
+		if result := matchPath(splitPath(test.pattern), splitPath(test.path)); result != test.match {
+			t.Errorf("pattern=%s path=%s expected=%v result=%v", test.pattern, test.path, test.match, result)
+		}
+	}
+}
+
+func TestSplitBaselineErrors(t *testing.T) {
+
+	bad := []map[string]interface{}{
+		{baselineKey: "not-a-map"},
+		{baselineKey: map[string]interface{}{"unknown": true}},
+		{baselineKey: map[string]interface{}{"slices": map[string]interface{}{"a": "sorted"}}},
+		{baselineKey: map[string]interface{}{"keys": map[string]interface{}{"a": ""}}},
+		{baselineKey: map[string]interface{}{"ignore": []interface{}{""}}},
+	}
+
+	for _, target := range bad {
+		if _, _, err := splitBaseline(target); err == nil {
+			t.Errorf("expected error for target=%v", target)
+		}
+	}
+}
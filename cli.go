@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// cliUsage: command line mode, when the binary runs with arguments outside Lambda
+const cliUsage = `usage: aws-config-lambda <command> [options]
+
+Baseline maintenance, sharing code with the Lambda function.
+
+commands:
+  get     -type T -id ID [-exclude p,...]                           print latest config item of resource, filtered
+  filter  [-exclude p,...] [file]                                   remove excluded paths from document (default: stdin)
+  upload  -baseline URI (-key K | -type T -id ID) [file]            store document as baseline (default: stdin)
+  list    (-type T | -tag key=value)                                list resources: type and id per line
+  save    -baseline URI (-type T | -tag key=value) [-exclude p,...] capture baselines of all resources found
+
+Run 'aws-config-lambda <command> -h' for command options.
+`
+
+// cli: command line mode
+type cli struct {
+	getClients func(ctx context.Context, bucket string) *Clients
+	stdin      io.Reader
+	stdout     io.Writer // command output
+	stderr     io.Writer // errors and usage
+}
+
+// runCLI: run command line, returning exit status
+func runCLI(args []string) int {
+	c := cli{
+		getClients: func(ctx context.Context, bucket string) *Clients { return getConfig(ctx, bucket, "") },
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+	}
+	// shared code logs to the logger in ctx: keep stdout for command output
+	return c.run(withLogger(context.Background(), logger{level: levelWarn, out: c.stderr}), args)
+}
+
+func (c cli) run(ctx context.Context, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(c.stderr, cliUsage)
+		return 2
+	}
+
+	var err error
+
+	switch cmd, cmdArgs := args[0], args[1:]; cmd {
+	case "get":
+		err = c.get(ctx, cmdArgs)
+	case "filter":
+		err = c.filter(cmdArgs)
+	case "upload":
+		err = c.upload(ctx, cmdArgs)
+	case "list":
+		err = c.list(ctx, cmdArgs)
+	case "save":
+		err = c.save(ctx, cmdArgs)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(c.stderr, "unknown command: %s\n\n%s", cmd, cliUsage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func (c cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// exclusions: patterns from flag -exclude, default like MissingBaseline=CAPTURE
+func exclusions(list string, given bool) [][]string {
+	if !given {
+		return splitPatterns(defaultCaptureExclude)
+	}
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return splitPatterns(patterns)
+}
+
+// flagGiven: flag explicitly set in command line
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	var given bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+func (c cli) clients(ctx context.Context, bucket string) (*Clients, error) {
+	clients := c.getClients(ctx, bucket)
+	if clients == nil {
+		return nil, fmt.Errorf("could not get aws client")
+	}
+	return clients, nil
+}
+
+// latestItem: latest config item of resource, from resource config history
+func latestItem(ctx context.Context, config ConfigAPI, resourceType, resourceId string) (configurationItem, error) {
+	itemHistory, errHistory := getHistory(ctx, config, resourceType, resourceId)
+	if errHistory != nil {
+		return configurationItem{}, errHistory
+	}
+	return itemFromHistory(itemHistory)
+}
+
+func (c cli) get(ctx context.Context, args []string) error {
+	fs := c.flags("get")
+	resourceType := fs.String("type", "AWS::EC2::Instance", "resource type")
+	resourceId := fs.String("id", "", "resource id (required)")
+	exclude := fs.String("exclude", strings.Join(defaultCaptureExclude, ","), "comma-separated path patterns to remove")
+	if errFlags := fs.Parse(args); errFlags != nil {
+		return errFlags
+	}
+	if *resourceId == "" {
+		return fmt.Errorf("missing -id")
+	}
+
+	clients, errClients := c.clients(ctx, "")
+	if errClients != nil {
+		return errClients
+	}
+
+	item, errItem := latestItem(ctx, clients.Config, *resourceType, *resourceId)
+	if errItem != nil {
+		return errItem
+	}
+
+	return c.printJSON(excludePaths(item.Payload, exclusions(*exclude, flagGiven(fs, "exclude"))))
+}
+
+func (c cli) filter(args []string) error {
+	fs := c.flags("filter")
+	exclude := fs.String("exclude", strings.Join(defaultCaptureExclude, ","), "comma-separated path patterns to remove")
+	if errFlags := fs.Parse(args); errFlags != nil {
+		return errFlags
+	}
+
+	buf, errRead := c.input(fs.Args())
+	if errRead != nil {
+		return errRead
+	}
+
+	doc := map[string]interface{}{}
+	if errJson := json.Unmarshal(buf, &doc); errJson != nil {
+		return errJson
+	}
+
+	// accept output of 'aws configservice get-resource-config-history' as well
+	if items, isSlice := doc["configurationItems"].([]interface{}); isSlice {
+		if len(items) < 1 {
+			return fmt.Errorf("no config items")
+		}
+		first, isMap := items[0].(map[string]interface{})
+		if !isMap {
+			return fmt.Errorf("bad config item: %v", items[0])
+		}
+		doc = first
+	}
+
+	return c.printJSON(excludePaths(doc, exclusions(*exclude, flagGiven(fs, "exclude"))))
+}
+
+func (c cli) upload(ctx context.Context, args []string) error {
+	fs := c.flags("upload")
+	baseline := fs.String("baseline", "", "baseline store URI: s3://bucket/prefix, file:///dir, ssm:///prefix, dynamodb://table (required)")
+	key := fs.String("key", "", "baseline key, overrides -type and -id")
+	resourceType := fs.String("type", "AWS::EC2::Instance", "resource type")
+	resourceId := fs.String("id", "", "resource id")
+	keyTemplate := fs.String("key-template", defaultKeyTemplate, "layout of baseline keys, with {resourceType} and {resourceId}")
+	overwrite := fs.Bool("overwrite", true, "replace existing baseline")
+	if errFlags := fs.Parse(args); errFlags != nil {
+		return errFlags
+	}
+
+	store, _, errStore := c.store(ctx, *baseline, false)
+	if errStore != nil {
+		return errStore
+	}
+
+	k := *key
+	if k == "" {
+		if *resourceId == "" {
+			return fmt.Errorf("missing -key or -id")
+		}
+		t, errTemplate := parseKeyTemplate(*keyTemplate)
+		if errTemplate != nil {
+			return errTemplate
+		}
+		var errKey error
+		k, errKey = t.render(configurationItem{ResourceType: *resourceType, ResourceId: *resourceId})
+		if errKey != nil {
+			return fmt.Errorf("%v: use -key", errKey)
+		}
+	}
+
+	buf, errRead := c.input(fs.Args())
+	if errRead != nil {
+		return errRead
+	}
+	var doc map[string]interface{}
+	if errJson := json.Unmarshal(buf, &doc); errJson != nil {
+		return fmt.Errorf("document: %v", errJson)
+	}
+
+	errPut := retryStore(ctx, func() error {
+		return store.Put(ctx, k, buf, *overwrite)
+	})
+	if errPut != nil {
+		return errPut
+	}
+
+	fmt.Fprintf(c.stdout, "uploaded: %s\n", store.Location(k))
+
+	return nil
+}
+
+func (c cli) list(ctx context.Context, args []string) error {
+	fs := c.flags("list")
+	resourceType := fs.String("type", "", "resource type")
+	tag := fs.String("tag", "", "tag filter key=value")
+	if errFlags := fs.Parse(args); errFlags != nil {
+		return errFlags
+	}
+
+	clients, errClients := c.clients(ctx, "")
+	if errClients != nil {
+		return errClients
+	}
+
+	refs, errList := findResources(ctx, clients.Config, *resourceType, *tag)
+	if errList != nil {
+		return errList
+	}
+
+	for _, r := range refs {
+		fmt.Fprintf(c.stdout, "%s %s\n", r.ResourceType, r.ResourceId)
+	}
+
+	return nil
+}
+
+func (c cli) save(ctx context.Context, args []string) error {
+	fs := c.flags("save")
+	baseline := fs.String("baseline", "", "baseline store URI: s3://bucket/prefix, file:///dir, ssm:///prefix, dynamodb://table (required)")
+	resourceType := fs.String("type", "", "resource type")
+	tag := fs.String("tag", "", "tag filter key=value")
+	exclude := fs.String("exclude", strings.Join(defaultCaptureExclude, ","), "comma-separated path patterns to remove")
+	keyTemplate := fs.String("key-template", defaultKeyTemplate, "layout of baseline keys, see rule parameter KeyTemplate")
+	overwrite := fs.Bool("overwrite", true, "replace existing baselines")
+	if errFlags := fs.Parse(args); errFlags != nil {
+		return errFlags
+	}
+
+	t, errTemplate := parseKeyTemplate(*keyTemplate)
+	if errTemplate != nil {
+		return errTemplate
+	}
+
+	store, clients, errStore := c.store(ctx, *baseline, true)
+	if errStore != nil {
+		return errStore
+	}
+
+	refs, errList := findResources(ctx, clients.Config, *resourceType, *tag)
+	if errList != nil {
+		return errList
+	}
+
+	patterns := exclusions(*exclude, flagGiven(fs, "exclude"))
+	by := "aws-config-lambda save"
+	if u, errUser := user.Current(); errUser == nil {
+		by += " user=" + u.Username
+	}
+
+	var failures int
+
+	for _, r := range refs {
+		location, errSave := saveResource(ctx, clients.Config, store, t, r, patterns, by, *overwrite)
+		if errSave != nil {
+			fmt.Fprintf(c.stderr, "save: %s %s: %v\n", r.ResourceType, r.ResourceId, errSave)
+			failures++
+			continue
+		}
+		fmt.Fprintf(c.stdout, "saved: %s %s: %s\n", r.ResourceType, r.ResourceId, location)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d resources not saved", failures, len(refs))
+	}
+
+	return nil
+}
+
+// saveResource: capture latest item of resource as its baseline
+func saveResource(ctx context.Context, config ConfigAPI, store BaselineStore, t keyTemplate, r resourceRef, patterns [][]string, by string, overwrite bool) (string, error) {
+	item, errItem := latestItem(ctx, config, r.ResourceType, r.ResourceId)
+	if errItem != nil {
+		return "", errItem
+	}
+	key, errKey := t.render(item)
+	if errKey != nil {
+		return "", errKey
+	}
+	doc, errDoc := captureDocument(item, patterns, by)
+	if errDoc != nil {
+		return "", errDoc
+	}
+	errPut := retryStore(ctx, func() error {
+		return store.Put(ctx, key, doc, overwrite)
+	})
+	return store.Location(key), errPut
+}
+
+// store: baseline store for URI, with AWS clients unless not needed by local files
+func (c cli) store(ctx context.Context, baseline string, needClients bool) (BaselineStore, *Clients, error) {
+	u, errURI := parseBaselineURI(baseline)
+	if errURI != nil {
+		return nil, nil, fmt.Errorf("-baseline: %v", errURI)
+	}
+	if u.scheme == schemeFile && !needClients {
+		return newStore(&Clients{}, u), nil, nil
+	}
+	var bucket string
+	if u.scheme == schemeS3 {
+		bucket = u.location
+	}
+	clients, errClients := c.clients(ctx, bucket)
+	if errClients != nil {
+		return nil, nil, errClients
+	}
+	return newStore(clients, u), clients, nil
+}
+
+// input: contents of file named in args, or stdin
+func (c cli) input(args []string) ([]byte, error) {
+	switch len(args) {
+	case 0:
+		return ioutil.ReadAll(c.stdin)
+	case 1:
+		return ioutil.ReadFile(args[0])
+	}
+	return nil, fmt.Errorf("too many arguments: %v", args)
+}
+
+func (c cli) printJSON(v interface{}) error {
+	buf, errJson := json.MarshalIndent(v, "", "  ")
+	if errJson != nil {
+		return errJson
+	}
+	_, errWrite := fmt.Fprintln(c.stdout, string(buf))
+	return errWrite
+}
+
+// resourceRef: resource found by list
+type resourceRef struct {
+	ResourceType string `json:"resourceType"`
+	ResourceId   string `json:"resourceId"`
+}
+
+// findResources: resources by tag key=value, optionally restricted to type, or all resources of type
+func findResources(ctx context.Context, config ConfigAPI, resourceType, tag string) ([]resourceRef, error) {
+	if tag == "" {
+		if resourceType == "" {
+			return nil, fmt.Errorf("missing -type or -tag")
+		}
+		ids, errList := listResources(ctx, config, resourceType)
+		if errList != nil {
+			return nil, errList
+		}
+		refs := make([]resourceRef, 0, len(ids))
+		for _, id := range ids {
+			refs = append(refs, resourceRef{ResourceType: resourceType, ResourceId: id})
+		}
+		return refs, nil
+	}
+
+	expr, errExpr := selectExpression(resourceType, tag)
+	if errExpr != nil {
+		return nil, errExpr
+	}
+
+	var refs []resourceRef
+
+	params := configservice.SelectResourceConfigInput{
+		Expression: aws.String(expr),
+		Limit:      aws.Int64(100),
+	}
+
+	for {
+		var resp *configservice.SelectResourceConfigOutput
+		errSelect := retry(ctx, "SelectResourceConfig", func() error {
+			var errCall error
+			resp, errCall = config.SelectResourceConfig(ctx, &params)
+			return errCall
+		})
+		if errSelect != nil {
+			return refs, errSelect
+		}
+
+		for _, result := range resp.Results {
+			var r resourceRef
+			if errJson := json.Unmarshal([]byte(result), &r); errJson != nil {
+				return refs, fmt.Errorf("SelectResourceConfig result: %v: %s", errJson, result)
+			}
+			refs = append(refs, r)
+		}
+
+		if resp.NextToken == nil || *resp.NextToken == "" {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+
+	return refs, nil
+}
+
+// selectExpression: advanced query for resources holding tag key=value
+func selectExpression(resourceType, tag string) (string, error) {
+	if !strings.Contains(tag, "=") || strings.HasPrefix(tag, "=") {
+		return "", fmt.Errorf("bad tag filter, expected key=value: %s", tag)
+	}
+	if strings.ContainsAny(tag+resourceType, "'\\") {
+		return "", fmt.Errorf("quotes not supported in filter: %s %s", resourceType, tag)
+	}
+	expr := fmt.Sprintf("SELECT resourceId, resourceType WHERE tags.tag = '%s'", tag)
+	if resourceType != "" {
+		expr += fmt.Sprintf(" AND resourceType = '%s'", resourceType)
+	}
+	return expr, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+func cliItem(resourceType configservice.ResourceType, resourceId, configuration string, tags map[string]string) configservice.ConfigurationItem {
+	capture := time.Date(2019, 6, 10, 14, 21, 7, 0, time.UTC)
+	return configservice.ConfigurationItem{
+		ConfigurationItemStatus:      configservice.ConfigurationItemStatusOk,
+		ResourceType:                 resourceType,
+		ResourceId:                   aws.String(resourceId),
+		ConfigurationItemCaptureTime: &capture,
+		Configuration:                aws.String(configuration),
+		Tags:                         tags,
+		Version:                      aws.String("1.3"),
+	}
+}
+
+func newTestCLI(clients *Clients, stdin string) (cli, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	c := cli{
+		getClients: func(ctx context.Context, bucket string) *Clients { return clients },
+		stdin:      strings.NewReader(stdin),
+		stdout:     &stdout,
+		stderr:     &stderr,
+	}
+	return c, &stdout, &stderr
+}
+
+func TestCLI(t *testing.T) {
+
+	config := &FakeConfig{
+		Items: []configservice.ConfigurationItem{
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-1", `{"instanceType":"t2.micro","networkInterfaces":[{"interfaceType":"interface","subnetId":"s-1"}]}`, map[string]string{"env": "prod"}),
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-2", `{"instanceType":"t2.large"}`, map[string]string{"env": "dev"}),
+			cliItem(configservice.ResourceTypeAwsEc2SecurityGroup, "sg-1", `{"groupName":"web"}`, map[string]string{"env": "prod"}),
+		},
+	}
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		status int
+		stdout string // expected substring
+		stderr string // expected substring
+	}{
+		{nil, "", 2, "", "usage:"},
+		{[]string{"bogus"}, "", 2, "", "unknown command: bogus"},
+		{[]string{"help"}, "", 0, "usage:", ""},
+		{[]string{"get", "-id", "i-1"}, "", 0, `"resourceId": "i-1"`, ""},
+		{[]string{"get", "-id", "i-9"}, "", 1, "", "get: "},
+		{[]string{"get"}, "", 1, "", "get: missing -id"},
+		{[]string{"filter"}, `{"version":"1.3","configuration":"{\"a\":1,\"networkInterfaces\":[{\"interfaceType\":\"x\"}]}"}`, 0, `"configuration": "{\"a\":1,\"networkInterfaces\":[{}]}"`, ""},
+		{[]string{"filter", "-exclude", "a"}, `{"configurationItems":[{"a":1,"b":2}]}`, 0, `"b": 2`, ""},
+		{[]string{"filter"}, `{"configurationItems":[]}`, 1, "", "filter: no config items"},
+		{[]string{"filter"}, `[]`, 1, "", "filter: "},
+		{[]string{"list", "-type", "AWS::EC2::Instance"}, "", 0, "AWS::EC2::Instance i-1\nAWS::EC2::Instance i-2\n", ""},
+		{[]string{"list", "-tag", "env=prod"}, "", 0, "AWS::EC2::Instance i-1\nAWS::EC2::SecurityGroup sg-1\n", ""},
+		{[]string{"list", "-tag", "env=prod", "-type", "AWS::EC2::SecurityGroup"}, "", 0, "AWS::EC2::SecurityGroup sg-1\n", ""},
+		{[]string{"list", "-tag", "env='x'"}, "", 1, "", "quotes not supported"},
+		{[]string{"list", "-tag", "env"}, "", 1, "", "bad tag filter"},
+		{[]string{"list"}, "", 1, "", "missing -type or -tag"},
+		{[]string{"upload", "-baseline", "ftp://x", "-id", "i-1"}, "{}", 1, "", "-baseline: "},
+		{[]string{"upload", "-baseline", "s3://bucket"}, "{}", 1, "", "missing -key or -id"},
+		{[]string{"upload", "-baseline", "s3://bucket", "-id", "i-1"}, "not json", 1, "", "document: "},
+		{[]string{"upload", "-baseline", "s3://bucket/baselines", "-id", "i-1"}, "{}", 0, "uploaded: s3://bucket/baselines/AWS::EC2::Instance/i-1\n", ""},
+		{[]string{"upload", "-baseline", "s3://bucket", "-key", "shared/ec2"}, "{}", 0, "uploaded: s3://bucket/shared/ec2\n", ""},
+	}
+
+	for _, test := range tests {
+		clients := &Clients{Config: config, S3: &FakeS3{}}
+		c, stdout, stderr := newTestCLI(clients, test.stdin)
+		status := c.run(context.Background(), test.args)
+		if status != test.status {
+			t.Errorf("args=%v status: expected=%d got=%d stderr=[%s]", test.args, test.status, status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), test.stdout) {
+			t.Errorf("args=%v stdout: expected=[%s] got=[%s]", test.args, test.stdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("args=%v stderr: expected=[%s] got=[%s]", test.args, test.stderr, stderr.String())
+		}
+	}
+}
+
+func TestCLIGetExclude(t *testing.T) {
+	config := &FakeConfig{
+		Items: []configservice.ConfigurationItem{
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-1", `{"instanceType":"t2.micro","networkInterfaces":[{"interfaceType":"interface","subnetId":"s-1"}]}`, nil),
+		},
+	}
+	c, stdout, stderr := newTestCLI(&Clients{Config: config}, "")
+	if status := c.run(context.Background(), []string{"get", "-id", "i-1"}); status != 0 {
+		t.Fatalf("status=%d stderr=%s", status, stderr.String())
+	}
+
+	doc := map[string]interface{}{}
+	if errJson := json.Unmarshal(stdout.Bytes(), &doc); errJson != nil {
+		t.Fatalf("output: %v", errJson)
+	}
+	if _, found := doc["version"]; found {
+		t.Errorf("version not excluded: %s", stdout.String())
+	}
+	if doc["resourceId"] != "i-1" {
+		t.Errorf("resourceId missing: %s", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "interfaceType") {
+		t.Errorf("interfaceType not excluded: %s", stdout.String())
+	}
+}
+
+func TestCLISave(t *testing.T) {
+	config := &FakeConfig{
+		Items: []configservice.ConfigurationItem{
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-1", `{"instanceType":"t2.micro"}`, map[string]string{"env": "prod"}),
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-2", `{"instanceType":"t2.large"}`, map[string]string{"env": "dev"}),
+		},
+	}
+
+	dir, errDir := ioutil.TempDir("", "cli-save")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer os.RemoveAll(dir)
+
+	c, stdout, stderr := newTestCLI(&Clients{Config: config}, "")
+	status := c.run(context.Background(), []string{"save", "-baseline", "file://" + dir, "-tag", "env=prod", "-key-template", "{tag:env}/{resourceId}"})
+	if status != 0 {
+		t.Fatalf("status=%d stderr=%s", status, stderr.String())
+	}
+	if expect := "saved: AWS::EC2::Instance i-1: file://" + filepath.Join(dir, "prod", "i-1"); !strings.Contains(stdout.String(), expect) {
+		t.Errorf("stdout: expected=[%s] got=[%s]", expect, stdout.String())
+	}
+
+	buf, errRead := ioutil.ReadFile(filepath.Join(dir, "prod", "i-1"))
+	if errRead != nil {
+		t.Fatalf("baseline not saved: %v", errRead)
+	}
+	var doc struct {
+		Rules baselineRules `json:"$baseline"`
+	}
+	if errJson := json.Unmarshal(buf, &doc); errJson != nil {
+		t.Fatalf("saved baseline: %v", errJson)
+	}
+	if doc.Rules.Captured == nil || !strings.HasPrefix(doc.Rules.Captured.By, "aws-config-lambda save") {
+		t.Errorf("capture info: %s", buf)
+	}
+	if _, errStat := os.Stat(filepath.Join(dir, "dev", "i-2")); errStat == nil {
+		t.Errorf("resource outside tag filter saved")
+	}
+
+	// existing baselines are kept without -overwrite=true
+	c, _, stderr = newTestCLI(&Clients{Config: config}, "")
+	status = c.run(context.Background(), []string{"save", "-baseline", "file://" + dir, "-tag", "env=prod", "-key-template", "{tag:env}/{resourceId}", "-overwrite=false"})
+	if status != 1 {
+		t.Errorf("save over existing baseline: status=%d", status)
+	}
+	if !strings.Contains(stderr.String(), "1 of 1 resources not saved") {
+		t.Errorf("stderr: %s", stderr.String())
+	}
+}
+
+func TestCLISaveEvaluateEvent(t *testing.T) {
+	// tag keys keep their case from history, as in change events
+	config := &FakeConfig{
+		Items: []configservice.ConfigurationItem{
+			cliItem(configservice.ResourceTypeAwsEc2Instance, "i-1", `{"instanceType":"t2.micro"}`, map[string]string{"Name": "web"}),
+		},
+	}
+
+	dir, errDir := ioutil.TempDir("", "cli-save")
+	if errDir != nil {
+		t.Fatal(errDir)
+	}
+	defer os.RemoveAll(dir)
+
+	c, _, stderr := newTestCLI(&Clients{Config: config}, "")
+	if status := c.run(context.Background(), []string{"save", "-baseline", "file://" + dir, "-tag", "Name=web"}); status != 0 {
+		t.Fatalf("status=%d stderr=%s", status, stderr.String())
+	}
+
+	u, errURI := parseBaselineURI("file://" + dir)
+	if errURI != nil {
+		t.Fatal(errURI)
+	}
+	store := newStore(&Clients{}, u)
+
+	event := `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{` +
+		`"configurationItemStatus":"OK","resourceType":"AWS::EC2::Instance","resourceId":"i-1",` +
+		`"configurationItemCaptureTime":"2019-06-11T09:00:00.000Z",` +
+		`"availabilityZone":null,"awsRegion":null,"resourceName":null,"resourceCreationTime":null,` + // unset in history item
+		`"relationships":null,"supplementaryConfiguration":null,` +
+		`"tags":{"Name":"web"},"configuration":{"instanceType":"t2.micro"}}}`
+	invoking, errEvent := parseInvokingEvent(event)
+	if errEvent != nil {
+		t.Fatal(errEvent)
+	}
+
+	r := rule{name: "drift"}
+	r.keyTemplate = defaultKeyTemplate
+	res, errEval := eval(context.Background(), store, invoking.change.ConfigurationItem, []baselineSource{{key: "AWS::EC2::Instance/i-1"}}, r)
+	if errEval != nil {
+		t.Fatalf("eval: %v", errEval)
+	}
+	if res.compliance != configservice.ComplianceTypeCompliant {
+		t.Errorf("expected compliant, got: %s %s %s", res.compliance, res.annotation, res.drift.annotation())
+	}
+}
+
+func TestRunCLIKeepsStdout(t *testing.T) {
+	stdout := os.Stdout
+	if status := runCLI([]string{"bogus"}); status != 2 {
+		t.Errorf("status: expected=2 got=%d", status)
+	}
+	if os.Stdout != stdout {
+		os.Stdout = stdout
+		t.Errorf("runCLI replaced os.Stdout")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ConfigAPI: config service calls used by the rule and the command line
+type ConfigAPI interface {
+	GetResourceConfigHistory(context.Context, *configservice.GetResourceConfigHistoryInput) (*configservice.GetResourceConfigHistoryOutput, error)
+	ListDiscoveredResources(context.Context, *configservice.ListDiscoveredResourcesInput) (*configservice.ListDiscoveredResourcesOutput, error)
+	PutEvaluations(context.Context, *configservice.PutEvaluationsInput) (*configservice.PutEvaluationsOutput, error)
+	SelectResourceConfig(context.Context, *configservice.SelectResourceConfigInput) (*configservice.SelectResourceConfigOutput, error)
+}
+
+// S3API: s3 calls used by the rule
+type S3API interface {
+	GetObject(context.Context, *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	// PutObjectIfAbsent: PutObject failing with s3ErrCodePreconditionFailed if the object exists
+	PutObjectIfAbsent(context.Context, *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+}
+
+// s3ErrCodePreconditionFailed: conditional write rejected, not in this SDK version
+const s3ErrCodePreconditionFailed = "PreconditionFailed"
+
+// SNSAPI: sns calls used by the rule
+type SNSAPI interface {
+	Publish(context.Context, *sns.PublishInput) (*sns.PublishOutput, error)
+}
+
+// SSMAPI: ssm calls used by baseline store ssm://
+type SSMAPI interface {
+	GetParameter(context.Context, *ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+	PutParameter(context.Context, *ssm.PutParameterInput) (*ssm.PutParameterOutput, error)
+}
+
+// DynamoDBAPI: dynamodb calls used by baseline store dynamodb://
+type DynamoDBAPI interface {
+	GetItem(context.Context, *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+}
+
+// Clients: AWS dependencies of the rule
+type Clients struct {
+	Config   ConfigAPI
+	S3       S3API
+	SNS      SNSAPI
+	SSM      SSMAPI
+	DynamoDB DynamoDBAPI
+}
+
+// getConfig: clients for default region from environment (AWS_REGION in Lambda).
+// S3 and SNS clients follow the region in Bucket and TopicArn ARNs, if any.
+func getConfig(ctx context.Context, bucket, topicArn string) *Clients {
+	cfg, errConfig := loadConfig(external.LoadDefaultAWSConfig)
+	if errConfig != nil {
+		loggerFrom(ctx).error("getConfig", logStage, "clients", logOutcome, "failed", logError, errConfig)
+		return nil
+	}
+	return newClients(ctx, cfg, targetRegions(cfg, bucket, topicArn))
+}
+
+// loadConfig: AWS config from load, which must define region
+func loadConfig(load func(...external.Config) (aws.Config, error)) (aws.Config, error) {
+	cfg, errLoad := load()
+	if errLoad != nil {
+		return cfg, errLoad
+	}
+	if cfg.Region == "" {
+		return cfg, fmt.Errorf("missing region: please set AWS_REGION")
+	}
+	return cfg, nil
+}
+
+// clientRegions: regions of clients, S3 and SNS following the region of their targets
+type clientRegions struct {
+	region string
+	s3     string
+	sns    string
+}
+
+func targetRegions(cfg aws.Config, bucket, topicArn string) clientRegions {
+	return clientRegions{
+		region: cfg.Region,
+		s3:     clientRegion(cfg.Region, bucket),
+		sns:    clientRegion(cfg.Region, topicArn),
+	}
+}
+
+// newClients: SDK clients for regions.
+// SDK retries are disabled: calls are retried by retry, whose attempts and
+// waits must be the only ones, to fit within the invocation deadline.
+func newClients(ctx context.Context, cfg aws.Config, regions clientRegions) *Clients {
+
+	cfg.Retryer = aws.DefaultRetryer{NumMaxRetries: 0}
+
+	cfgS3 := cfg.Copy()
+	cfgS3.Region = regions.s3
+
+	cfgSns := cfg.Copy()
+	cfgSns.Region = regions.sns
+
+	loggerFrom(ctx).debug("getConfig", logStage, "clients", logOutcome, "ok", "region", regions.region, "s3Region", regions.s3, "snsRegion", regions.sns)
+
+	c := Clients{
+		Config:   configClient{configservice.New(cfg)},
+		S3:       s3Client{s3.New(cfgS3)},
+		SNS:      snsClient{sns.New(cfgSns)},
+		SSM:      ssmClient{ssm.New(cfg)},
+		DynamoDB: dynamoClient{dynamodb.New(cfg)},
+	}
+
+	return &c
+}
+
+// clientCache: clients built once per container and reused by warm invocations.
+// The AWS config is reloaded, and clients rebuilt, when credentials expire or
+// are rejected, or when the environment defining region or credentials changes.
+// Clients for another target region, like a bucket in another region, are cached apart.
+type clientCache struct {
+	load    func(...external.Config) (aws.Config, error)
+	mutex   sync.Mutex
+	cfg     *aws.Config // nil: not loaded
+	env     string      // configEnv when cfg was loaded
+	expired bool        // credentials rejected by AWS, see expire
+	clients map[clientRegions]*Clients
+}
+
+func newClientCache(load func(...external.Config) (aws.Config, error)) *clientCache {
+	return &clientCache{load: load}
+}
+
+// get: cached clients for bucket and topic regions, refreshed if stale
+func (c *clientCache) get(ctx context.Context, bucket, topicArn string) *Clients {
+
+	log := loggerFrom(ctx).with(logStage, "clients")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if reason := c.stale(); reason != "" {
+		cfg, errConfig := loadConfig(c.load)
+		if errConfig != nil {
+			log.error("getConfig", logOutcome, "failed", logError, errConfig, "reason", reason)
+			return nil
+		}
+		log.info("getConfig: loaded", "reason", reason)
+		c.cfg = &cfg
+		c.env = configEnv()
+		c.expired = false
+		c.clients = map[clientRegions]*Clients{}
+	}
+
+	regions := targetRegions(*c.cfg, bucket, topicArn)
+	if clients, found := c.clients[regions]; found {
+		log.debug("getConfig: reusing clients", "region", regions.region, "s3Region", regions.s3, "snsRegion", regions.sns)
+		return clients
+	}
+
+	clients := newClients(ctx, *c.cfg, regions)
+	c.clients[regions] = clients
+	return clients
+}
+
+// stale: reason to reload config, or empty
+func (c *clientCache) stale() string {
+	switch {
+	case c.cfg == nil:
+		return "first use"
+	case c.expired:
+		return "credentials rejected"
+	case configEnv() != c.env:
+		return "environment changed"
+	}
+	creds, errCreds := c.cfg.Credentials.Retrieve()
+	if errCreds != nil {
+		return fmt.Sprintf("credentials: %v", errCreds)
+	}
+	if creds.Expired() {
+		return "credentials expired"
+	}
+	return ""
+}
+
+// expire: reload config on next get, if err reports expired or invalid credentials
+func (c *clientCache) expire(err error) {
+	if !isExpiredCredentials(err) {
+		return
+	}
+	c.mutex.Lock()
+	c.expired = true
+	c.mutex.Unlock()
+}
+
+// expiredCodes: AWS error codes for credentials no longer accepted
+var expiredCodes = map[string]bool{
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnrecognizedClientException": true,
+}
+
+func isExpiredCredentials(err error) bool {
+	awsErr, isAws := err.(awserr.Error)
+	return isAws && expiredCodes[awsErr.Code()]
+}
+
+// configEnv: environment variables selecting region and credentials
+func configEnv() string {
+	var b strings.Builder
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SESSION_TOKEN"} {
+		b.WriteString(name + "=" + os.Getenv(name) + "\n")
+	}
+	return b.String()
+}
+
+// configClient: ConfigAPI on top of SDK client
+type configClient struct {
+	client *configservice.Client
+}
+
+func (c configClient) GetResourceConfigHistory(ctx context.Context, input *configservice.GetResourceConfigHistoryInput) (*configservice.GetResourceConfigHistoryOutput, error) {
+	resp, err := c.client.GetResourceConfigHistoryRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetResourceConfigHistoryOutput, nil
+}
+
+func (c configClient) ListDiscoveredResources(ctx context.Context, input *configservice.ListDiscoveredResourcesInput) (*configservice.ListDiscoveredResourcesOutput, error) {
+	resp, err := c.client.ListDiscoveredResourcesRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.ListDiscoveredResourcesOutput, nil
+}
+
+func (c configClient) PutEvaluations(ctx context.Context, input *configservice.PutEvaluationsInput) (*configservice.PutEvaluationsOutput, error) {
+	resp, err := c.client.PutEvaluationsRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PutEvaluationsOutput, nil
+}
+
+func (c configClient) SelectResourceConfig(ctx context.Context, input *configservice.SelectResourceConfigInput) (*configservice.SelectResourceConfigOutput, error) {
+	resp, err := c.client.SelectResourceConfigRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.SelectResourceConfigOutput, nil
+}
+
+// s3Client: S3API on top of SDK client
+type s3Client struct {
+	client *s3.Client
+}
+
+func (c s3Client) GetObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	resp, err := c.client.GetObjectRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetObjectOutput, nil
+}
+
+func (c s3Client) PutObject(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	resp, err := c.client.PutObjectRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PutObjectOutput, nil
+}
+
+// PutObjectIfAbsent: the SDK version in use has no field for If-None-Match, so the header is added to the request
+func (c s3Client) PutObjectIfAbsent(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	req := c.client.PutObjectRequest(input)
+	req.Handlers.Build.PushBack(func(r *aws.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
+	})
+	resp, err := req.Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PutObjectOutput, nil
+}
+
+// snsClient: SNSAPI on top of SDK client
+type snsClient struct {
+	client *sns.Client
+}
+
+func (c snsClient) Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	resp, err := c.client.PublishRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PublishOutput, nil
+}
+
+// ssmClient: SSMAPI on top of SDK client
+type ssmClient struct {
+	client *ssm.Client
+}
+
+func (c ssmClient) GetParameter(ctx context.Context, input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	resp, err := c.client.GetParameterRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetParameterOutput, nil
+}
+
+func (c ssmClient) PutParameter(ctx context.Context, input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	resp, err := c.client.PutParameterRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PutParameterOutput, nil
+}
+
+// dynamoClient: DynamoDBAPI on top of SDK client
+type dynamoClient struct {
+	client *dynamodb.Client
+}
+
+func (c dynamoClient) GetItem(ctx context.Context, input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	resp, err := c.client.GetItemRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetItemOutput, nil
+}
+
+func (c dynamoClient) PutItem(ctx context.Context, input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	resp, err := c.client.PutItemRequest(input).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PutItemOutput, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// fakeCredentials: credentials expiring at expires, if set
+type fakeCredentials struct {
+	expires time.Time
+	err     error
+}
+
+func (f *fakeCredentials) Retrieve() (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret", CanExpire: !f.expires.IsZero(), Expires: f.expires}, f.err
+}
+
+func TestClientCache(t *testing.T) {
+	creds := &fakeCredentials{}
+	var loads int
+	cache := newClientCache(func(...external.Config) (aws.Config, error) {
+		loads++
+		cfg := aws.Config{Region: "sa-east-1", Credentials: creds}
+		return cfg, nil
+	})
+	ctx := context.Background()
+
+	first := cache.get(ctx, "baselines", "")
+	if first == nil {
+		t.Fatalf("no clients")
+	}
+	if again := cache.get(ctx, "baselines", ""); again != first || loads != 1 {
+		t.Errorf("clients not reused: loads=%d", loads)
+	}
+
+	// another target region gets its own clients, from the same config
+	other := cache.get(ctx, "arn:aws:s3:us-east-1::baselines", "")
+	if other == first || loads != 1 {
+		t.Errorf("bucket region: same=%v loads=%d", other == first, loads)
+	}
+	if again := cache.get(ctx, "arn:aws:s3:us-east-1::baselines", ""); again != other {
+		t.Errorf("clients for bucket region not reused")
+	}
+
+	// errors not about credentials keep clients
+	cache.expire(awserr.New("ThrottlingException", "slow down", nil))
+	if again := cache.get(ctx, "baselines", ""); again != first || loads != 1 {
+		t.Errorf("clients dropped on throttling: loads=%d", loads)
+	}
+
+	cache.expire(awserr.New("ExpiredTokenException", "token expired", nil))
+	refreshed := cache.get(ctx, "baselines", "")
+	if refreshed == first || loads != 2 {
+		t.Errorf("clients kept after rejected credentials: loads=%d", loads)
+	}
+
+	creds.expires = time.Now().Add(-time.Minute)
+	if again := cache.get(ctx, "baselines", ""); again == refreshed || loads != 3 {
+		t.Errorf("clients kept after credentials expired: loads=%d", loads)
+	}
+	creds.expires = time.Time{}
+
+	creds.err = fmt.Errorf("no credentials")
+	cache.get(ctx, "baselines", "")
+	if loads != 4 {
+		t.Errorf("config not reloaded after credentials failure: loads=%d", loads)
+	}
+	creds.err = nil
+}
+
+// throttlingTransport: HTTP transport answering every request with ThrottlingException
+type throttlingTransport struct {
+	requests int
+}
+
+func (f *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.requests++
+	body := `{"__type":"ThrottlingException","message":"Rate exceeded"}`
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestClientsNoSDKRetry(t *testing.T) {
+	transport := &throttlingTransport{}
+	cfg := defaults.Config()
+	cfg.Region = "sa-east-1"
+	cfg.Credentials = &fakeCredentials{}
+	cfg.HTTPClient = &http.Client{Transport: transport}
+
+	clients := newClients(context.Background(), cfg, targetRegions(cfg, "", ""))
+
+	input := &configservice.GetResourceConfigHistoryInput{ResourceType: configservice.ResourceTypeAwsEc2Instance, ResourceId: aws.String("i-1")}
+	if _, err := clients.Config.GetResourceConfigHistory(context.Background(), input); err == nil {
+		t.Fatalf("expected throttling error")
+	}
+	if transport.requests != 1 {
+		t.Errorf("SDK retried throttled call: requests=%d", transport.requests)
+	}
+
+	// retry makes exactly the attempts of its policy
+	transport.requests = 0
+	p := retryPolicy{attempts: 3, base: time.Millisecond, max: time.Millisecond}
+	err := retryWith(context.Background(), "GetResourceConfigHistory", p, func() error {
+		_, errHistory := clients.Config.GetResourceConfigHistory(context.Background(), input)
+		return errHistory
+	})
+	if err == nil || !isRetryable(err) {
+		t.Errorf("expected throttling error, got: %v", err)
+	}
+	if transport.requests != p.attempts {
+		t.Errorf("requests: expected=%d got=%d", p.attempts, transport.requests)
+	}
+}
+
+func TestClientCacheEnvironment(t *testing.T) {
+	region, hasRegion := os.LookupEnv("AWS_REGION")
+	defer func() {
+		if hasRegion {
+			os.Setenv("AWS_REGION", region)
+		} else {
+			os.Unsetenv("AWS_REGION")
+		}
+	}()
+
+	var loads int
+	cache := newClientCache(func(...external.Config) (aws.Config, error) {
+		loads++
+		return aws.Config{Region: os.Getenv("AWS_REGION"), Credentials: &fakeCredentials{}}, nil
+	})
+	ctx := context.Background()
+
+	os.Setenv("AWS_REGION", "sa-east-1")
+	first := cache.get(ctx, "baselines", "")
+
+	os.Setenv("AWS_REGION", "us-east-1")
+	if again := cache.get(ctx, "baselines", ""); again == first || loads != 2 {
+		t.Errorf("clients kept after region changed: loads=%d", loads)
+	}
+
+	os.Setenv("AWS_REGION", "")
+	if clients := cache.get(ctx, "baselines", ""); clients != nil {
+		t.Errorf("clients without region")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// messageType values in InvokingEvent
+// https://docs.aws.amazon.com/config/latest/developerguide/evaluate-config_develop-rules_example-events.html
+const (
+	messageChange    = "ConfigurationItemChangeNotification"
+	messageOversized = "OversizedConfigurationItemChangeNotification"
+	messageScheduled = "ScheduledNotification"
+)
+
+// invokingEvent: InvokingEvent decoded according to its messageType.
+// Exactly one of change, oversized, scheduled is set.
+type invokingEvent struct {
+	MessageType string
+	change      *changeNotification
+	oversized   *oversizedNotification
+	scheduled   *scheduledNotification
+}
+
+// changeNotification: ConfigurationItemChangeNotification carries the full item
+type changeNotification struct {
+	ConfigurationItem configurationItem
+}
+
+// oversizedNotification: OversizedConfigurationItemChangeNotification carries
+// only a summary, the item must be fetched from resource config history
+type oversizedNotification struct {
+	ResourceType string
+	ResourceId   string
+	CaptureTime  time.Time
+}
+
+// scheduledNotification: ScheduledNotification carries no item
+type scheduledNotification struct {
+	AwsAccountId             string
+	NotificationCreationTime time.Time
+}
+
+// configurationItem: fields needed to evaluate an item, plus the whole item
+// as generic map for comparison against baseline
+type configurationItem struct {
+	Status       string
+	ResourceType string
+	ResourceId   string
+	ResourceName string
+	AwsAccountId string
+	AwsRegion    string
+	CaptureTime  time.Time
+	Tags         map[string]string
+	Payload      map[string]interface{}
+}
+
+// invokingEventJSON: wire format of InvokingEvent
+type invokingEventJSON struct {
+	MessageType              string          `json:"messageType"`
+	AwsAccountId             string          `json:"awsAccountId"`
+	NotificationCreationTime string          `json:"notificationCreationTime"`
+	ConfigurationItem        json.RawMessage `json:"configurationItem"`
+	ConfigurationItemSummary *struct {
+		ResourceType                 string `json:"resourceType"`
+		ResourceId                   string `json:"resourceId"`
+		ConfigurationItemCaptureTime string `json:"configurationItemCaptureTime"`
+	} `json:"configurationItemSummary"`
+}
+
+// configurationItemJSON: wire format of header fields of configurationItem
+type configurationItemJSON struct {
+	ConfigurationItemStatus      string            `json:"configurationItemStatus"`
+	ResourceType                 string            `json:"resourceType"`
+	ResourceId                   string            `json:"resourceId"`
+	ResourceName                 string            `json:"resourceName"`
+	AwsAccountId                 string            `json:"awsAccountId"`
+	AwsRegion                    string            `json:"awsRegion"`
+	ConfigurationItemCaptureTime string            `json:"configurationItemCaptureTime"`
+	Tags                         map[string]string `json:"tags"`
+}
+
+// eventError: invalid InvokingEvent, naming the bad field
+type eventError struct {
+	field string
+	msg   string
+}
+
+func (e eventError) Error() string {
+	return fmt.Sprintf("InvokingEvent: %s: %s", e.field, e.msg)
+}
+
+func requireString(field, value string) error {
+	if value == "" {
+		return eventError{field, "missing"}
+	}
+	return nil
+}
+
+func requireTime(field, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, eventError{field, "missing"}
+	}
+	t, errTime := time.Parse(time.RFC3339, value)
+	if errTime != nil {
+		return t, eventError{field, fmt.Sprintf("bad time '%s': %v", value, errTime)}
+	}
+	return t, nil
+}
+
+// parseInvokingEvent: decode and validate InvokingEvent
+func parseInvokingEvent(s string) (invokingEvent, error) {
+	var e invokingEvent
+
+	var w invokingEventJSON
+	if errJson := json.Unmarshal([]byte(s), &w); errJson != nil {
+		return e, fmt.Errorf("InvokingEvent: %v", errJson)
+	}
+
+	e.MessageType = w.MessageType
+
+	switch w.MessageType {
+	case messageChange:
+		if len(w.ConfigurationItem) == 0 || string(w.ConfigurationItem) == "null" {
+			return e, eventError{"configurationItem", "missing"}
+		}
+		item, errItem := parseConfigurationItem(w.ConfigurationItem)
+		if errItem != nil {
+			return e, errItem
+		}
+		e.change = &changeNotification{ConfigurationItem: item}
+	case messageOversized:
+		summ := w.ConfigurationItemSummary
+		if summ == nil {
+			return e, eventError{"configurationItemSummary", "missing"}
+		}
+		if errType := requireString("configurationItemSummary.resourceType", summ.ResourceType); errType != nil {
+			return e, errType
+		}
+		if errId := requireString("configurationItemSummary.resourceId", summ.ResourceId); errId != nil {
+			return e, errId
+		}
+		o := oversizedNotification{ResourceType: summ.ResourceType, ResourceId: summ.ResourceId}
+		if summ.ConfigurationItemCaptureTime != "" {
+			t, errTime := requireTime("configurationItemSummary.configurationItemCaptureTime", summ.ConfigurationItemCaptureTime)
+			if errTime != nil {
+				return e, errTime
+			}
+			o.CaptureTime = t
+		}
+		e.oversized = &o
+	case messageScheduled:
+		t, errTime := requireTime("notificationCreationTime", w.NotificationCreationTime)
+		if errTime != nil {
+			return e, errTime
+		}
+		e.scheduled = &scheduledNotification{AwsAccountId: w.AwsAccountId, NotificationCreationTime: t}
+	case "":
+		return e, eventError{"messageType", "missing"}
+	default:
+		return e, eventError{"messageType", fmt.Sprintf("unsupported: %s", w.MessageType)}
+	}
+
+	return e, nil
+}
+
+// parseConfigurationItem: decode and validate configurationItem from event
+func parseConfigurationItem(raw json.RawMessage) (configurationItem, error) {
+	var item configurationItem
+
+	var w configurationItemJSON
+	if errJson := json.Unmarshal(raw, &w); errJson != nil {
+		return item, eventError{"configurationItem", errJson.Error()}
+	}
+	if errJson := json.Unmarshal(raw, &item.Payload); errJson != nil {
+		return item, eventError{"configurationItem", errJson.Error()}
+	}
+
+	return newConfigurationItem(w, "configurationItem.", item.Payload)
+}
+
+// itemFromHistory: configurationItem from resource config history.
+// Keys in payload are converted to event format, like configurationItemStatus.
+func itemFromHistory(ci configservice.ConfigurationItem) (configurationItem, error) {
+	payload, errToMap := itemToMap(ci)
+	if errToMap != nil {
+		return configurationItem{}, errToMap
+	}
+
+	w := configurationItemJSON{
+		ConfigurationItemStatus: string(ci.ConfigurationItemStatus),
+		ResourceType:            string(ci.ResourceType),
+		Tags:                    ci.Tags,
+	}
+	if ci.ResourceId != nil {
+		w.ResourceId = *ci.ResourceId
+	}
+	if ci.ResourceName != nil {
+		w.ResourceName = *ci.ResourceName
+	}
+	if ci.AccountId != nil {
+		w.AwsAccountId = *ci.AccountId
+	}
+	if ci.AwsRegion != nil {
+		w.AwsRegion = *ci.AwsRegion
+	}
+	if ci.ConfigurationItemCaptureTime != nil {
+		w.ConfigurationItemCaptureTime = ci.ConfigurationItemCaptureTime.Format(time.RFC3339)
+	}
+
+	return newConfigurationItem(w, "history item.", payload)
+}
+
+func newConfigurationItem(w configurationItemJSON, fieldPrefix string, payload map[string]interface{}) (configurationItem, error) {
+	item := configurationItem{
+		Status:       w.ConfigurationItemStatus,
+		ResourceType: w.ResourceType,
+		ResourceId:   w.ResourceId,
+		ResourceName: w.ResourceName,
+		AwsAccountId: w.AwsAccountId,
+		AwsRegion:    w.AwsRegion,
+		Tags:         w.Tags,
+		Payload:      payload,
+	}
+
+	if errStatus := requireString(fieldPrefix+"configurationItemStatus", w.ConfigurationItemStatus); errStatus != nil {
+		return item, errStatus
+	}
+	if errType := requireString(fieldPrefix+"resourceType", w.ResourceType); errType != nil {
+		return item, errType
+	}
+	if errId := requireString(fieldPrefix+"resourceId", w.ResourceId); errId != nil {
+		return item, errId
+	}
+	t, errTime := requireTime(fieldPrefix+"configurationItemCaptureTime", w.ConfigurationItemCaptureTime)
+	if errTime != nil {
+		return item, errTime
+	}
+	item.CaptureTime = t
+
+	return item, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseInvokingEvent(t *testing.T) {
+
+	tests := []struct {
+		event string
+		err   string
+	}{
+		{
+			event: `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{"configurationItemStatus":"OK","resourceType":"AWS::EC2::Instance","resourceId":"i-1","configurationItemCaptureTime":"2019-06-10T14:21:07.123Z"}}`,
+			err:   "",
+		},
+		{
+			event: `{"messageType":"ConfigurationItemChangeNotification"}`,
+			err:   "InvokingEvent: configurationItem: missing",
+		},
+		{
+			event: `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{"configurationItemStatus":"OK","resourceType":"AWS::EC2::Instance","configurationItemCaptureTime":"2019-06-10T14:21:07Z"}}`,
+			err:   "InvokingEvent: configurationItem.resourceId: missing",
+		},
+		{
+			event: `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{"configurationItemStatus":"OK","resourceType":"AWS::EC2::Instance","resourceId":"i-1","configurationItemCaptureTime":"yesterday"}}`,
+			err:   `InvokingEvent: configurationItem.configurationItemCaptureTime: bad time 'yesterday': parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+		},
+		{
+			event: `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{"configurationItemStatus":1}}`,
+			err:   "InvokingEvent: configurationItem: json: cannot unmarshal number into Go struct field configurationItemJSON.configurationItemStatus of type string",
+		},
+		{
+			event: `{"messageType":"OversizedConfigurationItemChangeNotification","configurationItemSummary":{"resourceType":"AWS::EC2::Instance","resourceId":"i-1"}}`,
+			err:   "",
+		},
+		{
+			event: `{"messageType":"OversizedConfigurationItemChangeNotification","configurationItemSummary":{"resourceId":"i-1"}}`,
+			err:   "InvokingEvent: configurationItemSummary.resourceType: missing",
+		},
+		{
+			event: `{"messageType":"OversizedConfigurationItemChangeNotification"}`,
+			err:   "InvokingEvent: configurationItemSummary: missing",
+		},
+		{
+			event: `{"messageType":"ScheduledNotification","notificationCreationTime":"2019-06-11T00:00:00.000Z"}`,
+			err:   "",
+		},
+		{
+			event: `{"messageType":"ScheduledNotification"}`,
+			err:   "InvokingEvent: notificationCreationTime: missing",
+		},
+		{
+			event: `{"messageType":"Unknown"}`,
+			err:   "InvokingEvent: messageType: unsupported: Unknown",
+		},
+		{
+			event: `{}`,
+			err:   "InvokingEvent: messageType: missing",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := parseInvokingEvent(test.event)
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("event=%s\nexpected error: %s\ngot error: %s", test.event, test.err, errStr)
+		}
+	}
+}
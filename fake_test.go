@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// FakeConfig: in-memory config service
+type FakeConfig struct {
+	Items       []configservice.ConfigurationItem // latest item of each resource
+	Evaluations []configservice.Evaluation        // evaluations submitted
+	PutCalls    []int                             // evaluations sent in each PutEvaluations call
+	FailPuts    int                               // first PutEvaluations calls report every evaluation as failed
+	PutErrs     []error                           // returned by the first PutEvaluations calls, one per call
+}
+
+func (f *FakeConfig) GetResourceConfigHistory(ctx context.Context, input *configservice.GetResourceConfigHistoryInput) (*configservice.GetResourceConfigHistoryOutput, error) {
+	for _, item := range f.Items {
+		if item.ResourceType == input.ResourceType && *item.ResourceId == *input.ResourceId {
+			return &configservice.GetResourceConfigHistoryOutput{ConfigurationItems: []configservice.ConfigurationItem{item}}, nil
+		}
+	}
+	return nil, awserr.New(configservice.ErrCodeResourceNotDiscoveredException, "resource not discovered", nil)
+}
+
+func (f *FakeConfig) ListDiscoveredResources(ctx context.Context, input *configservice.ListDiscoveredResourcesInput) (*configservice.ListDiscoveredResourcesOutput, error) {
+	var ids []configservice.ResourceIdentifier
+	for _, item := range f.Items {
+		if item.ResourceType == input.ResourceType {
+			ids = append(ids, configservice.ResourceIdentifier{ResourceId: item.ResourceId, ResourceType: item.ResourceType})
+		}
+	}
+
+	// paginate: next token is index of first resource in next page
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	end := len(ids)
+	if input.Limit != nil && start+int(*input.Limit) < end {
+		end = start + int(*input.Limit)
+	}
+	out := configservice.ListDiscoveredResourcesOutput{ResourceIdentifiers: ids[start:end]}
+	if end < len(ids) {
+		next := strconv.Itoa(end)
+		out.NextToken = &next
+	}
+	return &out, nil
+}
+
+func (f *FakeConfig) PutEvaluations(ctx context.Context, input *configservice.PutEvaluationsInput) (*configservice.PutEvaluationsOutput, error) {
+	f.PutCalls = append(f.PutCalls, len(input.Evaluations))
+	if n := len(f.PutCalls); n <= len(f.PutErrs) && f.PutErrs[n-1] != nil {
+		return nil, f.PutErrs[n-1]
+	}
+	if len(f.PutCalls) <= f.FailPuts {
+		return &configservice.PutEvaluationsOutput{FailedEvaluations: input.Evaluations}, nil
+	}
+	f.Evaluations = append(f.Evaluations, input.Evaluations...)
+	return &configservice.PutEvaluationsOutput{}, nil
+}
+
+// SelectResourceConfig: supports only expressions built by selectExpression
+func (f *FakeConfig) SelectResourceConfig(ctx context.Context, input *configservice.SelectResourceConfigInput) (*configservice.SelectResourceConfigOutput, error) {
+	expr := *input.Expression
+	var results []string
+	for _, item := range f.Items {
+		if strings.Contains(expr, "resourceType = ") && !strings.Contains(expr, "resourceType = '"+string(item.ResourceType)+"'") {
+			continue
+		}
+		var tagged bool
+		for k, v := range item.Tags {
+			if strings.Contains(expr, "tags.tag = '"+k+"="+v+"'") {
+				tagged = true
+			}
+		}
+		if strings.Contains(expr, "tags.tag = ") && !tagged {
+			continue
+		}
+		results = append(results, `{"resourceId":"`+*item.ResourceId+`","resourceType":"`+string(item.ResourceType)+`"}`)
+	}
+	return &configservice.SelectResourceConfigOutput{Results: results}, nil
+}
+
+// FakeS3: in-memory s3, objects keyed by bucket/key
+type FakeS3 struct {
+	Objects map[string]string
+	GetErr  error // returned by every GetObject call, if set
+	Gets    int   // GetObject calls
+}
+
+func (f *FakeS3) GetObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.Gets++
+	if f.GetErr != nil {
+		return nil, f.GetErr
+	}
+	body, found := f.Objects[*input.Bucket+"/"+*input.Key]
+	if !found {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func (f *FakeS3) PutObject(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, errRead := ioutil.ReadAll(input.Body)
+	if errRead != nil {
+		return nil, errRead
+	}
+	if f.Objects == nil {
+		f.Objects = map[string]string{}
+	}
+	f.Objects[*input.Bucket+"/"+*input.Key] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *FakeS3) PutObjectIfAbsent(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if _, found := f.Objects[*input.Bucket+"/"+*input.Key]; found {
+		return nil, awserr.New(s3ErrCodePreconditionFailed, "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	return f.PutObject(ctx, input)
+}
+
+// FakeSNS: in-memory sns
+type FakeSNS struct {
+	Published    []sns.PublishInput
+	PublishCalls int
+	PublishErrs  []error // returned by the first Publish calls, one per call
+}
+
+func (f *FakeSNS) Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	f.PublishCalls++
+	if f.PublishCalls <= len(f.PublishErrs) && f.PublishErrs[f.PublishCalls-1] != nil {
+		return nil, f.PublishErrs[f.PublishCalls-1]
+	}
+	f.Published = append(f.Published, *input)
+	return &sns.PublishOutput{}, nil
+}
+
+// FakeSSM: in-memory parameter store, values keyed by parameter name
+type FakeSSM struct {
+	Parameters map[string]string
+}
+
+func (f *FakeSSM) GetParameter(ctx context.Context, input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	value, found := f.Parameters[*input.Name]
+	if !found {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Name: input.Name, Value: &value}}, nil
+}
+
+func (f *FakeSSM) PutParameter(ctx context.Context, input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	if _, found := f.Parameters[*input.Name]; found && !aws.BoolValue(input.Overwrite) {
+		return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, "parameter already exists", nil)
+	}
+	if f.Parameters == nil {
+		f.Parameters = map[string]string{}
+	}
+	limit := 4096 // Standard tier
+	if input.Tier == ssm.ParameterTierAdvanced || input.Tier == ssmTierIntelligentTiering {
+		limit = 8192
+	}
+	if len(*input.Value) > limit {
+		return nil, awserr.New("ValidationException", "parameter value too long for tier", nil)
+	}
+	f.Parameters[*input.Name] = *input.Value
+	return &ssm.PutParameterOutput{}, nil
+}
+
+// FakeDynamoDB: in-memory dynamodb, items keyed by table/key, with key from string attribute
+type FakeDynamoDB struct {
+	Items        map[string]map[string]dynamodb.AttributeValue
+	KeyAttribute string // key attribute of items written by PutItem, default "key"
+}
+
+func (f *FakeDynamoDB) GetItem(ctx context.Context, input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	for _, v := range input.Key {
+		if item, found := f.Items[*input.TableName+"/"+*v.S]; found {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (f *FakeDynamoDB) PutItem(ctx context.Context, input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	keyAttr := f.KeyAttribute
+	if keyAttr == "" {
+		keyAttr = "key"
+	}
+	key := *input.TableName + "/" + aws.StringValue(input.Item[keyAttr].S)
+	if _, found := f.Items[key]; found && input.ConditionExpression != nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	}
+	if f.Items == nil {
+		f.Items = map[string]map[string]dynamodb.AttributeValue{}
+	}
+	f.Items[key] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// FakeStore: in-memory baseline store, documents keyed by baseline key
+type FakeStore struct {
+	Documents map[string]string
+}
+
+func (f *FakeStore) Get(ctx context.Context, key string) ([]byte, error) {
+	doc, found := f.Documents[key]
+	if !found {
+		return nil, notFoundError{f.Location(key)}
+	}
+	return []byte(doc), nil
+}
+
+func (f *FakeStore) Put(ctx context.Context, key string, doc []byte, overwrite bool) error {
+	if _, found := f.Documents[key]; found && !overwrite {
+		return existsError{f.Location(key)}
+	}
+	if f.Documents == nil {
+		f.Documents = map[string]string{}
+	}
+	f.Documents[key] = string(doc)
+	return nil
+}
+
+func (f *FakeStore) Location(key string) string {
+	return "fake://" + key
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// defaultCaptureExclude: fields removed from captured baselines.
+// Item metadata (versions, hashes, ARN, account, capture time and status) and
+// related events change with every recorded item, not with the configuration,
+// so a baseline holding them would drift at once. Relationship names and
+// network interface types are dropped too, since they vary without any
+// configuration change. Fields are listed in both event and history key
+// formats, like accountId and awsAccountId.
+var defaultCaptureExclude = []string{
+	"version",
+	"configurationItemVersion",
+	"configurationItemMD5Hash",
+	"configurationStateMd5Hash",
+	"arn",
+	"ARN",
+	"configurationItemCaptureTime",
+	"configurationItemStatus",
+	"configurationStateId",
+	"accountId",
+	"awsAccountId",
+	"relatedEvents",
+	"relationships[*].relationshipName",
+	"configuration.networkInterfaces[*].interfaceType",
+}
+
+// excludePaths: copy of doc without values at paths matching patterns (see matchPath).
+// Maps encoded as JSON strings, like configuration, are decoded, filtered and encoded back.
+func excludePaths(doc map[string]interface{}, patterns [][]string) map[string]interface{} {
+	return excludeMap("", doc, patterns)
+}
+
+func excludeMap(path string, m map[string]interface{}, patterns [][]string) map[string]interface{} {
+	clean := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		p := pathKey(path, k)
+		if excluded(p, patterns) {
+			continue
+		}
+		clean[k] = excludeValue(p, v, patterns)
+	}
+	return clean
+}
+
+func excludeValue(path string, v interface{}, patterns [][]string) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		return excludeMap(path, vv, patterns)
+	case []interface{}:
+		clean := make([]interface{}, 0, len(vv))
+		for i, e := range vv {
+			p := pathIndex(path, i)
+			if excluded(p, patterns) {
+				continue
+			}
+			clean = append(clean, excludeValue(p, e, patterns))
+		}
+		return clean
+	}
+	if m, isJSON := decodeStrJsonMap(v); isJSON {
+		buf, errJson := json.Marshal(excludeMap(path, m, patterns))
+		if errJson != nil {
+			return v
+		}
+		return string(buf)
+	}
+	return v
+}
+
+func excluded(path string, patterns [][]string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	segments := splitPath(path)
+	for _, p := range patterns {
+		if matchPath(p, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPatterns: split path patterns for excludePaths
+func splitPatterns(list []string) [][]string {
+	patterns := make([][]string, 0, len(list))
+	for _, p := range list {
+		patterns = append(patterns, splitPath(p))
+	}
+	return patterns
+}
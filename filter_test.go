@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExcludePaths(t *testing.T) {
+
+	tests := []struct {
+		doc      string
+		patterns []string
+		expected string
+	}{
+		{
+			doc:      `{"a":1,"b":2,"c":{"d":3,"e":4}}`,
+			patterns: []string{"b", "c.e"},
+			expected: `{"a":1,"c":{"d":3}}`,
+		},
+		{
+			doc:      `{"relationships":[{"resourceId":"sg-1","relationshipName":"Is associated with"},{"resourceId":"vpc-1","relationshipName":"Is contained in"}]}`,
+			patterns: []string{"relationships[*].relationshipName"},
+			expected: `{"relationships":[{"resourceId":"sg-1"},{"resourceId":"vpc-1"}]}`,
+		},
+		{
+			// inside string-encoded json
+			doc:      `{"configuration":"{\"instanceType\":\"t2.micro\",\"networkInterfaces\":[{\"interfaceType\":\"interface\",\"networkInterfaceId\":\"eni-1\"}]}"}`,
+			patterns: []string{"configuration.networkInterfaces[*].interfaceType"},
+			expected: `{"configuration":"{\"instanceType\":\"t2.micro\",\"networkInterfaces\":[{\"networkInterfaceId\":\"eni-1\"}]}"}`,
+		},
+		{
+			doc:      `{"tags":{"Name":"web","owner":"ops"},"list":[1,2,3]}`,
+			patterns: []string{"tags.*", "list[1]"},
+			expected: `{"tags":{},"list":[1,3]}`,
+		},
+		{
+			doc:      `{"tags":{"kubernetes.io/cluster/x":"owned","Name":"web"}}`,
+			patterns: []string{`tags.kubernetes\.io/cluster/x`},
+			expected: `{"tags":{"Name":"web"}}`,
+		},
+		{
+			doc:      `{"a":1}`,
+			patterns: nil,
+			expected: `{"a":1}`,
+		},
+	}
+
+	for _, test := range tests {
+		var doc, expected map[string]interface{}
+		if errJson := json.Unmarshal([]byte(test.doc), &doc); errJson != nil {
+			t.Errorf("doc: %v", errJson)
+			continue
+		}
+		if errJson := json.Unmarshal([]byte(test.expected), &expected); errJson != nil {
+			t.Errorf("expected: %v", errJson)
+			continue
+		}
+		clean := excludePaths(doc, splitPatterns(test.patterns))
+		if !reflect.DeepEqual(clean, expected) {
+			buf, _ := json.Marshal(clean)
+			t.Errorf("doc=%s patterns=%v\nexpected: %s\ngot:      %s", test.doc, test.patterns, test.expected, string(buf))
+		}
+	}
+}
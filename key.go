@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placeholders accepted by parameter KeyTemplate
+const (
+	keyAccountId    = "{accountId}"
+	keyRegion       = "{region}"
+	keyResourceType = "{resourceType}"
+	keyResourceId   = "{resourceId}"
+	keyResourceName = "{resourceName}"
+	keyTagPrefix    = "{tag:" // {tag:role} => value of tag role
+)
+
+// defaultKeyTemplate: baselines of distinct resource types never collide,
+// like a security group and the inventory recorded for the same instance id.
+const defaultKeyTemplate = keyResourceType + "/" + keyResourceId
+
+// keyTemplate: layout of baseline keys in store, like {accountId}/{region}/{resourceType}/{resourceId}
+type keyTemplate string
+
+// parseKeyTemplate: validate placeholders.
+// The template must identify a single resource, so it requires {resourceId} or {resourceName}.
+func parseKeyTemplate(s string) (keyTemplate, error) {
+	t, ident, errTemplate := parseTemplate(s)
+	if errTemplate != nil {
+		return t, errTemplate
+	}
+	if !ident {
+		return "", fmt.Errorf("missing %s or %s: %s", keyResourceId, keyResourceName, s)
+	}
+	return t, nil
+}
+
+// parseTemplate: validate placeholders, reporting whether the template identifies a single resource
+func parseTemplate(s string) (keyTemplate, bool, error) {
+	rest := s
+	var ident bool
+	for {
+		begin := strings.IndexByte(rest, '{')
+		end := strings.IndexByte(rest, '}')
+		if begin < 0 {
+			if end >= 0 {
+				return "", false, fmt.Errorf("unbalanced '}': %s", s)
+			}
+			break
+		}
+		if end < begin {
+			return "", false, fmt.Errorf("unbalanced braces: %s", s)
+		}
+		switch p := rest[begin : end+1]; p {
+		case keyResourceId, keyResourceName:
+			ident = true
+		case keyAccountId, keyRegion, keyResourceType:
+		default:
+			if !strings.HasPrefix(p, keyTagPrefix) || len(p) == len(keyTagPrefix)+1 || strings.ContainsAny(p[1:len(p)-1], "{") {
+				return "", false, fmt.Errorf("unknown placeholder %s (expected %s, %s, %s, %s, %s or %sname}): %s", p, keyAccountId, keyRegion, keyResourceType, keyResourceId, keyResourceName, keyTagPrefix, s)
+			}
+		}
+		rest = rest[end+1:]
+	}
+	return keyTemplate(s), ident, nil
+}
+
+// render: baseline key for item.
+// Placeholders used by the template must not be empty in item.
+func (t keyTemplate) render(item configurationItem) (string, error) {
+	values := map[string]string{
+		keyAccountId:    item.AwsAccountId,
+		keyRegion:       item.AwsRegion,
+		keyResourceType: item.ResourceType,
+		keyResourceId:   item.ResourceId,
+		keyResourceName: item.ResourceName,
+	}
+	var b strings.Builder
+	rest := string(t)
+	for {
+		begin := strings.IndexByte(rest, '{')
+		if begin < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest, '}')
+		p := rest[begin : end+1]
+		if strings.HasPrefix(p, keyTagPrefix) {
+			values[p] = item.Tags[p[len(keyTagPrefix):len(p)-1]]
+		}
+		if values[p] == "" {
+			return "", fmt.Errorf("key template %s: empty %s for resourceType=%s resourceId=%s", t, p, item.ResourceType, item.ResourceId)
+		}
+		b.WriteString(rest[:begin])
+		b.WriteString(values[p])
+		rest = rest[end+1:]
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeyTemplate(t *testing.T) {
+
+	item := configurationItem{
+		AwsAccountId: "123456789012",
+		AwsRegion:    "sa-east-1",
+		ResourceType: "AWS::EC2::Instance",
+		ResourceId:   "i-1",
+		Tags:         map[string]string{"role": "web"},
+	}
+
+	tests := []struct {
+		template string
+		key      string
+		err      string
+	}{
+		{template: defaultKeyTemplate, key: "AWS::EC2::Instance/i-1"},
+		{template: "{resourceId}", key: "i-1"},
+		{template: "{accountId}/{region}/{resourceType}/{resourceId}.json", key: "123456789012/sa-east-1/AWS::EC2::Instance/i-1.json"},
+		{template: "{tag:role}/{resourceId}", key: "web/i-1"},
+		{template: "{tag:env}/{resourceId}", err: "key template {tag:env}/{resourceId}: empty {tag:env} for resourceType=AWS::EC2::Instance resourceId=i-1"},
+		{template: "{tag:}/{resourceId}", err: "unknown placeholder {tag:} (expected {accountId}, {region}, {resourceType}, {resourceId}, {resourceName} or {tag:name}): {tag:}/{resourceId}"},
+		{template: "{resourceType}/{resourceName}", err: "key template {resourceType}/{resourceName}: empty {resourceName} for resourceType=AWS::EC2::Instance resourceId=i-1"},
+		{template: "{resourceType}", err: "missing {resourceId} or {resourceName}: {resourceType}"},
+		{template: "{resourceType}/{id}", err: "unknown placeholder {id} (expected {accountId}, {region}, {resourceType}, {resourceId}, {resourceName} or {tag:name}): {resourceType}/{id}"},
+		{template: "{resourceId", err: "unbalanced braces: {resourceId"},
+		{template: "resourceId}", err: "unbalanced '}': resourceId}"},
+	}
+
+	for _, test := range tests {
+		tmpl, errParse := parseKeyTemplate(test.template)
+		var key string
+		var errRender error
+		if errParse == nil {
+			key, errRender = tmpl.render(item)
+		}
+		var errStr string
+		switch {
+		case errParse != nil:
+			errStr = errParse.Error()
+		case errRender != nil:
+			errStr = errRender.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("template=%s\nexpected error: %s\ngot error: %s", test.template, test.err, errStr)
+			continue
+		}
+		if key != test.key {
+			t.Errorf("template=%s key: expected=%s got=%s", test.template, test.key, key)
+		}
+	}
+}
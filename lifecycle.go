@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+)
+
+// Lifecycle metric names
+const (
+	metricColdStart          = "ColdStart"          // 1 for the first invocation in a container, else 0
+	metricInvocationDuration = "InvocationDuration" // milliseconds in Handle
+	metricStageDuration      = "StageDuration"      // milliseconds in each stage, by Stage
+	dimStage                 = "Stage"
+)
+
+// invocation: lifecycle of one Handle call
+type invocation struct {
+	number     int  // invocations handled by the same RuleHandler, starting at 1
+	coldStart  bool // first invocation of the RuleHandler, thus of the container for the Lambda handler
+	start      time.Time
+	stage      string // current stage, empty if none
+	stageStart time.Time
+	stages     []stageTiming // finished stages, in order
+}
+
+// stageTiming: time spent in one stage of invocation
+type stageTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// begin: track new invocation of handler
+func (h *RuleHandler) begin() *invocation {
+	h.mutex.Lock()
+	h.invocations++
+	number := h.invocations
+	h.mutex.Unlock()
+	now := time.Now()
+	return &invocation{number: number, coldStart: number == 1, start: now}
+}
+
+// enter: finish current stage, if any, and start stage
+func (inv *invocation) enter(stage string) {
+	inv.end()
+	inv.stage = stage
+	inv.stageStart = time.Now()
+}
+
+// end: finish current stage, if any
+func (inv *invocation) end() {
+	if inv.stage == "" {
+		return
+	}
+	inv.stages = append(inv.stages, stageTiming{name: inv.stage, duration: time.Since(inv.stageStart)})
+	inv.stage = ""
+}
+
+// fields: lifecycle key/value pairs for log events
+func (inv *invocation) fields() []interface{} {
+	return []interface{}{"invocation", inv.number, "coldStart", inv.coldStart}
+}
+
+// finish: log and record duration of invocation and of each stage.
+// Handle enters each stage at most once: a periodic evaluation lists, evaluates
+// and submits every resource within stage scheduled. Should a stage be entered
+// again, its durations are summed in the log and recorded as separate samples.
+func (inv *invocation) finish(log logger, m *metrics) {
+	inv.end()
+	total := time.Since(inv.start)
+
+	stages := map[string]float64{}
+	for _, s := range inv.stages {
+		stages[s.name] += milliseconds(s.duration)
+	}
+
+	var cold int
+	if inv.coldStart {
+		cold = 1
+	}
+	m.count(metricColdStart, "", "", cold)
+	m.latency(metricInvocationDuration, "", "", total)
+	for _, s := range inv.stages {
+		m.latency(metricStageDuration, dimStage, s.name, s.duration)
+	}
+
+	log.info("invocation done", "durationMs", milliseconds(total), "stagesMs", stages)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
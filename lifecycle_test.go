@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvocationNumbers(t *testing.T) {
+	t.Parallel()
+
+	// handlers track invocations independently, so tests may run in parallel
+	h1 := NewHandler(Clients{})
+	h2 := NewHandler(Clients{})
+
+	const n = 50
+	seen := make(chan *invocation, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen <- h1.begin()
+		}()
+	}
+	wg.Wait()
+	close(seen)
+
+	numbers := map[int]bool{}
+	var cold int
+	for inv := range seen {
+		numbers[inv.number] = true
+		if inv.coldStart {
+			cold++
+		}
+	}
+	if len(numbers) != n {
+		t.Errorf("invocation numbers not unique: %d of %d", len(numbers), n)
+	}
+	if cold != 1 {
+		t.Errorf("cold starts: expected=1 got=%d", cold)
+	}
+
+	if inv := h2.begin(); inv.number != 1 || !inv.coldStart {
+		t.Errorf("second handler: number=%d coldStart=%v", inv.number, inv.coldStart)
+	}
+	if inv := h2.begin(); inv.number != 2 || inv.coldStart {
+		t.Errorf("warm invocation: number=%d coldStart=%v", inv.number, inv.coldStart)
+	}
+}
+
+func TestInvocationFinish(t *testing.T) {
+	t.Parallel()
+
+	inv := NewHandler(Clients{}).begin()
+	inv.enter("parameters")
+	inv.enter("evaluate")
+	time.Sleep(2 * time.Millisecond)
+	inv.enter("submit")
+	inv.enter("evaluate")
+
+	var logBuf, metricsBuf bytes.Buffer
+	m := newMetrics("drift")
+	m.out = &metricsBuf
+	inv.finish(logger{out: &logBuf}, m)
+	m.flush()
+
+	if inv.stage != "" || len(inv.stages) != 4 {
+		t.Errorf("stages: current=%s finished=%v", inv.stage, inv.stages)
+	}
+
+	events := decodeEvents(t, &logBuf)
+	if len(events) != 1 || events[0]["msg"] != "invocation done" {
+		t.Fatalf("log: %s", logBuf.String())
+	}
+	stages, _ := events[0]["stagesMs"].(map[string]interface{})
+	if len(stages) != 3 {
+		t.Errorf("stages logged: %v", events[0]["stagesMs"])
+	}
+	if ms, _ := stages["evaluate"].(float64); ms < 2 {
+		t.Errorf("evaluate stage: %vms", stages["evaluate"])
+	}
+	if total, _ := events[0]["durationMs"].(float64); total < 2 {
+		t.Errorf("invocation duration: %vms", events[0]["durationMs"])
+	}
+
+	lines := decodeEvents(t, &metricsBuf)
+	if len(lines) != 4 { // no dimension, then stages evaluate, parameters, submit
+		t.Fatalf("metrics: %s", metricsBuf.String())
+	}
+	if lines[0][metricColdStart] != 1.0 {
+		t.Errorf("cold start metric: %v", lines[0])
+	}
+	if samples, isSlice := lines[1][metricStageDuration].([]interface{}); lines[1][dimStage] != "evaluate" || !isSlice || len(samples) != 2 {
+		t.Errorf("evaluate stage metric: %v", lines[1])
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// logLevel: severity of log events, least severe first.
+// The zero value is INFO, so a zero logger skips debug events.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota - 1
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l-levelDebug]
+}
+
+// parseLogLevel: level from name, case insensitive
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return levelDebug + logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("bad value '%s' (expected %s)", s, strings.Join(levelNames, ", "))
+}
+
+// Log event keys shared by many events, so they can be queried in CloudWatch Logs Insights
+const (
+	logRule          = "rule"          // config rule name
+	logRequestId     = "requestId"     // AWS request id of Lambda invocation
+	logCorrelationId = "correlationId" // id of invocation, also sent in alerts
+	logResourceType  = "resourceType"
+	logResourceId    = "resourceId"
+	logStage         = "stage"   // step of invocation: parameters, event, history, baseline, compare, submit, alert
+	logOutcome       = "outcome" // compliance, ok, or failed
+	logError         = "error"
+)
+
+// logger: emits one JSON object per line for each event at or above level.
+// Fields are attached to every event; a logger is immutable, so it is shared freely.
+type logger struct {
+	level  logLevel
+	fields map[string]interface{}
+	out    io.Writer // nil: os.Stdout at the time of the event
+}
+
+// logMutex: keeps lines from concurrent events whole
+var logMutex sync.Mutex
+
+// with: logger adding key/value pairs to every event
+func (l logger) with(kv ...interface{}) logger {
+	fields := make(map[string]interface{}, len(l.fields)+len(kv)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	addFields(fields, kv)
+	l.fields = fields
+	return l
+}
+
+// withLevel: logger emitting events at or above level
+func (l logger) withLevel(level logLevel) logger {
+	l.level = level
+	return l
+}
+
+// enabled: events at level are emitted, useful to skip building expensive fields
+func (l logger) enabled(level logLevel) bool {
+	return level >= l.level
+}
+
+func (l logger) debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l logger) info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l logger) warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l logger) error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+func (l logger) log(level logLevel, msg string, kv []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	event := make(map[string]interface{}, len(l.fields)+len(kv)/2+3)
+	for k, v := range l.fields {
+		event[k] = v
+	}
+	addFields(event, kv)
+	event["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	event["level"] = level.String()
+	event["msg"] = msg
+
+	buf, errJson := json.Marshal(event)
+	if errJson != nil {
+		// unsupported value in fields: log them as text
+		for k, v := range event {
+			event[k] = fmt.Sprint(v)
+		}
+		buf, _ = json.Marshal(event)
+	}
+
+	out := l.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	logMutex.Lock()
+	fmt.Fprintln(out, string(buf))
+	logMutex.Unlock()
+}
+
+// addFields: key/value pairs into fields, errors as text.
+// A key without value is recorded with value null.
+func addFields(fields map[string]interface{}, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		k := fmt.Sprint(kv[i])
+		var v interface{}
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		switch value := v.(type) {
+		case error:
+			v = value.Error()
+		case fmt.Stringer:
+			v = value.String()
+		}
+		fields[k] = v
+	}
+}
+
+type loggerKey struct{}
+
+// withLogger: ctx carrying l for functions called during invocation
+func withLogger(ctx context.Context, l logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom: logger in ctx, or logger at level INFO without fields
+func loggerFrom(ctx context.Context) logger {
+	if l, found := ctx.Value(loggerKey{}).(logger); found {
+		return l
+	}
+	return logger{}
+}
+
+// invocationLogger: logger identifying Lambda invocation in ctx by request id and a new correlation id
+func invocationLogger(ctx context.Context, ruleName string) logger {
+	l := logger{}.with(logCorrelationId, newCorrelationId())
+	if lc, found := lambdacontext.FromContext(ctx); found {
+		l = l.with(logRequestId, lc.AwsRequestID)
+	}
+	if ruleName != "" {
+		l = l.with(logRule, ruleName)
+	}
+	return l
+}
+
+// newCorrelationId: random 16-hex-digit id
+func newCorrelationId() string {
+	b := make([]byte, 8)
+	if _, errRand := rand.Read(b); errRand != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func decodeEvents(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		e := map[string]interface{}{}
+		if errJson := json.Unmarshal([]byte(line), &e); errJson != nil {
+			t.Fatalf("bad event: %v: %s", errJson, line)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	base := logger{out: &buf}.with(logRule, "drift")
+	l := base.with(logResourceId, "i-1")
+
+	l.debug("hidden")
+	l.info("evaluation", logOutcome, "COMPLIANT", "backoff", 200*time.Millisecond, logError, fmt.Errorf("boom"), "odd")
+	base.warn("without resource")
+	l.withLevel(levelDebug).debug("shown", "item", map[string]interface{}{"a": 1})
+
+	events := decodeEvents(t, &buf)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %s", len(events), buf.String())
+	}
+
+	e := events[0]
+	expect := map[string]interface{}{
+		"level": "INFO", "msg": "evaluation", logRule: "drift", logResourceId: "i-1",
+		logOutcome: "COMPLIANT", "backoff": "200ms", logError: "boom", "odd": nil,
+	}
+	for k, v := range expect {
+		if e[k] != v {
+			t.Errorf("event field %s: expected=%v got=%v", k, v, e[k])
+		}
+	}
+	if _, errTime := time.Parse(time.RFC3339Nano, fmt.Sprint(e["time"])); errTime != nil {
+		t.Errorf("event time: %v", errTime)
+	}
+
+	if _, found := events[1][logResourceId]; found {
+		t.Errorf("with changed parent logger: %v", events[1])
+	}
+	if events[2]["level"] != "DEBUG" {
+		t.Errorf("debug event: %v", events[2])
+	}
+	if item, isMap := events[2]["item"].(map[string]interface{}); !isMap || item["a"] != 1.0 {
+		t.Errorf("structured field: %v", events[2]["item"])
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, name := range []string{"DEBUG", "Info", "warn", "ERROR"} {
+		level, errLevel := parseLogLevel(name)
+		if errLevel != nil {
+			t.Errorf("%s: %v", name, errLevel)
+		}
+		if level.String() != strings.ToUpper(name) {
+			t.Errorf("%s: got %s", name, level)
+		}
+	}
+	if _, errLevel := parseLogLevel("TRACE"); errLevel == nil {
+		t.Errorf("TRACE: expected error")
+	}
+	if (logLevel(0)) != levelInfo {
+		t.Errorf("zero level: %s", logLevel(0))
+	}
+}
+
+func TestInvocationLogger(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+
+	l := invocationLogger(ctx, "drift")
+	if l.fields[logRequestId] != "req-1" || l.fields[logRule] != "drift" {
+		t.Errorf("invocation fields: %v", l.fields)
+	}
+	id, _ := l.fields[logCorrelationId].(string)
+	if len(id) != 16 {
+		t.Errorf("correlation id: %v", l.fields[logCorrelationId])
+	}
+	if other := invocationLogger(ctx, "drift"); other.fields[logCorrelationId] == id {
+		t.Errorf("correlation id reused: %s", id)
+	}
+
+	if from := loggerFrom(withLogger(context.Background(), l)); from.fields[logCorrelationId] != id {
+		t.Errorf("logger from ctx: %v", from.fields)
+	}
+	if from := loggerFrom(context.Background()); from.enabled(levelDebug) || len(from.fields) != 0 {
+		t.Errorf("default logger: %v", from)
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"os"
 	"runtime"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -15,218 +15,282 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/configservice"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
 	lambda.Start(Handler)
 }
 
+// RuleHandler: evaluates config rule events
+type RuleHandler struct {
+	getClients    func(ctx context.Context, bucket, topicArn string) *Clients
+	expireClients func(err error) // optional: drop clients when err reports expired credentials
+
+	mutex       sync.Mutex
+	invocations int // invocations handled, see begin
+}
+
+// NewHandler: handler using given clients for every invocation
+func NewHandler(clients Clients) *RuleHandler {
+	return &RuleHandler{
+		getClients: func(ctx context.Context, bucket, topicArn string) *Clients {
+			return &clients
+		},
+	}
+}
+
+// defaultClients: AWS clients from Lambda environment, reused by warm invocations
+var defaultClients = newClientCache(external.LoadDefaultAWSConfig)
+
+// defaultHandler: handler using AWS clients from Lambda environment
+var defaultHandler = &RuleHandler{getClients: defaultClients.get, expireClients: defaultClients.expire}
+
 type Out struct {
 	Str string
 }
 
 const version = "0.1"
 
-var count int
-
 // https://github.com/aws/aws-lambda-go/blob/master/events/README_Config.md
 // https://github.com/aws/aws-lambda-go/blob/master/events/config.go
 
-func Handler(ctx context.Context, configEvent events.ConfigEvent) (out Out, err error) {
+// Handler: lambda entry point
+func Handler(ctx context.Context, configEvent events.ConfigEvent) (Out, error) {
+	return defaultHandler.Handle(ctx, configEvent)
+}
 
-	out = Out{"ok"}
+// Handle: evaluate config rule event
+func (h *RuleHandler) Handle(ctx context.Context, configEvent events.ConfigEvent) (out Out, err error) {
 
-	fmt.Printf("version=%s runtime=%s GOMAXPROCS=%d OS=%s ARCH=%s\n", version, runtime.Version(), runtime.GOMAXPROCS(0), runtime.GOOS, runtime.GOARCH)
+	out = Out{"ok"}
 
-	count++
+	inv := h.begin()
 
-	var dumpConfigItem bool
-	var bucket string
-	restrictResourceTypes := map[string]struct{}{}
-	var topicArn string
-	var forceNonCompliance bool
+	log := invocationLogger(ctx, configEvent.ConfigRuleName).with(inv.fields()...)
 
-	if params := configEvent.RuleParameters; params != "" {
-		ruleParameters := map[string]string{}
-		if errParam := json.Unmarshal([]byte(params), &ruleParameters); errParam != nil {
-			fmt.Printf("RuleParameters: %v\n", errParam)
-		} else {
-			for k, v := range ruleParameters {
-				fmt.Printf("RuleParameters: %s=%s\n", k, v)
-			}
+	m := newMetrics(configEvent.ConfigRuleName)
+	ctx = withMetrics(ctx, m)
+	defer m.flush()
+	defer func() { inv.finish(log, m) }()
 
-			if dump, found := ruleParameters["Dump"]; found {
-				if dump == "ConfigItem" {
-					dumpConfigItem = true
-				}
-			}
+	// fail: report err as outcome of current stage
+	fail := func(e error) {
+		err = e
+		out.Str = err.Error()
+		log.error("invocation failed", logStage, inv.stage, logOutcome, "failed", logError, err)
+	}
 
-			if types, found := ruleParameters["ResourceTypes"]; found {
-				for _, s := range strings.Split(types, ",") {
-					restrictResourceTypes[s] = struct{}{}
-				}
-			}
+	log.info("invocation", "version", version, "runtime", runtime.Version(), "GOMAXPROCS", runtime.GOMAXPROCS(0), "OS", runtime.GOOS, "ARCH", runtime.GOARCH)
 
-			bucket = ruleParameters["Bucket"]
-			topicArn = ruleParameters["TopicArn"]
+	inv.enter("parameters")
 
-			if _, found := ruleParameters["ForceNonCompliance"]; found {
-				forceNonCompliance = true
-			}
-		}
+	params, errParams := parseParameters(configEvent.RuleParameters)
+	if errParams != nil {
+		fail(errParams)
+		return
 	}
 
-	if dumpConfigItem {
-		fmt.Printf("count=%d\n", count)
+	log = log.withLevel(params.logLevel)
+	log.debug("rule parameters", logStage, "parameters", "ruleParameters", configEvent.RuleParameters)
+	ctx = withLogger(ctx, log)
+
+	// work stops before Lambda deadline, leaving time to submit results
+	work, cancel := workContext(ctx)
+	defer cancel()
+
+	r := rule{
+		name:           configEvent.ConfigRuleName,
+		resultToken:    configEvent.ResultToken,
+		eventLeftScope: configEvent.EventLeftScope,
+		parameters:     params,
 	}
 
-	clientConf := getConfig()
+	inv.enter("clients")
+
+	clientConf := h.getClients(ctx, r.bucket, r.topicArn)
 	if clientConf == nil {
-		err = fmt.Errorf("could not get aws client - aborting")
-		out.Str = err.Error()
-		fmt.Println(out.Str)
+		fail(fmt.Errorf("could not get aws client - aborting"))
 		return
 	}
+	clientConf = instrument(clientConf, func(ctx context.Context, operation string, errAPI error) {
+		apiError(ctx, operation, errAPI)
+		if errAPI != nil && h.expireClients != nil {
+			h.expireClients(errAPI)
+		}
+	})
 
 	// InvokingEvent:
 	// If the event is published in response to a resource configuration change, this value contains a JSON configuration item
 	// https://github.com/aws/aws-lambda-go/blob/master/events/config.go
-	invokingEvent := map[string]interface{}{}
-	if errJson := json.Unmarshal([]byte(configEvent.InvokingEvent), &invokingEvent); errJson != nil {
-		err = fmt.Errorf("InvokingEvent: %v", errJson)
-		out.Str = err.Error()
-		fmt.Println(out.Str)
+	inv.enter("event")
+
+	invoking, errEvent := parseInvokingEvent(configEvent.InvokingEvent)
+	if errEvent != nil {
+		fail(errEvent)
 		return
 	}
 
-	// invokingEvent:
-	//   configurationItem: map
-	//   messageType: ConfigurationItemChangeNotification
+	if invoking.scheduled != nil {
+		inv.enter("scheduled")
+		return handleScheduled(ctx, work, clientConf, r, *invoking.scheduled)
+	}
 
-	item, foundItem := invokingEvent["configurationItem"]
-	if !foundItem {
-		fmt.Printf("'configurationItem' not found in InvokingEvent=%v\n", invokingEvent)
+	var item configurationItem
 
-		summary, foundSummary := invokingEvent["configurationItemSummary"]
-		if !foundSummary {
-			err = fmt.Errorf("'configurationItemSummary' not found in InvokingEvent=%v", invokingEvent)
-			out.Str = err.Error()
-			fmt.Println(out.Str)
-			return
-		}
+	if invoking.change != nil {
+		log.debug("config item from event", logStage, "event")
+		item = invoking.change.ConfigurationItem
+	} else {
+		summary := invoking.oversized
 
-		summ, summMap := summary.(map[string]interface{})
-		if !summMap {
-			err = fmt.Errorf("configurationItemSummary is not a map: %v", summary)
-			out.Str = err.Error()
-			fmt.Println(out.Str)
-			return
-		}
+		inv.enter("history")
 
-		resourceType := mapString(summ, "resourceType")
-		resourceId := mapString(summ, "resourceId")
+		log.debug("config item from service config history", logStage, "history", logResourceType, summary.ResourceType, logResourceId, summary.ResourceId)
 
-		itemHistory, errHistory := getHistory(clientConf.config, resourceType, resourceId)
+		itemHistory, errHistory := getHistory(work, clientConf.Config, summary.ResourceType, summary.ResourceId)
 		if errHistory != nil {
-			err = fmt.Errorf("getHistory: %v", errHistory)
-			out.Str = err.Error()
-			fmt.Println(out.Str)
+			fail(fmt.Errorf("getHistory: %v", errHistory))
 			return
 		}
 
-		itemMap, errToMap := itemToMap(itemHistory)
-		if errToMap != nil {
-			err = fmt.Errorf("history item to map: %v", errToMap)
-			out.Str = err.Error()
-			fmt.Println(out.Str)
+		itemFromHist, errItem := itemFromHistory(itemHistory)
+		if errItem != nil {
+			fail(fmt.Errorf("history item: %v", errItem))
 			return
 		}
 
-		item = itemMap
+		item = itemFromHist
 	}
 
-	if foundItem {
-		fmt.Println("config item from: event")
-	} else {
-		fmt.Println("config item from: service config history")
-	}
+	inv.enter("evaluate")
 
-	// Decode configuration item
+	sub := newSubmitter(clientConf.Config, r.resultToken)
 
-	configItem, itemMap := item.(map[string]interface{})
-	if !itemMap {
-		err = fmt.Errorf("configurationItem not a map: %v", item)
-		out.Str = err.Error()
-		fmt.Println(out.Str)
-		return
-	}
+	_, errEval := evalItem(work, clientConf, r, item, item.CaptureTime, sub)
 
-	if dumpConfigItem {
-		logItem("dump config item: ", configItem)
-	}
+	// submit queued evaluation even if alert failed
+	inv.enter("submit")
+
+	errSubmit := sub.flush(ctx)
 
-	status := mapString(configItem, "configurationItemStatus")
-	resourceType := mapString(configItem, "resourceType")
-	resourceId := mapString(configItem, "resourceId")
-	timestamp := mapString(configItem, "configurationItemCaptureTime")
-	t, errTime := time.Parse(time.RFC3339, timestamp)
-	if errTime != nil {
-		fmt.Printf("parse time: '%s': %v\n", timestamp, errTime)
+	switch {
+	case errEval != nil:
+		fail(errEval)
+	case errSubmit != nil:
+		fail(errSubmit)
 	}
 
-	if dumpConfigItem {
-		fmt.Printf("configuration item status: %s\n", status)
-		fmt.Printf("configuration item type: %s\n", resourceType)
-		fmt.Printf("configuration item id: %s\n", resourceId)
+	return
+}
+
+// rule: rule parameters and event fields needed to evaluate config items
+type rule struct {
+	name           string
+	resultToken    string
+	eventLeftScope bool
+	parameters
+}
+
+// evalItem: evaluate config item, queue result for config service and alert sns.
+// If ctx expires during evaluation, the result is abandoned and reported as error.
+func evalItem(ctx context.Context, clientConf *Clients, r rule, item configurationItem, timestamp time.Time, sub *submitter) (result, error) {
+
+	status := item.Status
+	resourceType := item.ResourceType
+	resourceId := item.ResourceId
+
+	log := loggerFrom(ctx).with(logResourceType, resourceType, logResourceId, resourceId)
+	ctx = withLogger(ctx, log)
+
+	if log.enabled(levelDebug) {
+		log.debug("config item", logStage, "evaluate", "status", status, "item", item.Payload)
 	}
 
 	// ComplianceType
 	// https://godoc.org/github.com/aws/aws-sdk-go-v2/service/configservice#ComplianceType
-	compliance := configservice.ComplianceTypeNotApplicable
-	annotation := ""
+	res := result{compliance: configservice.ComplianceTypeNotApplicable}
 
-	isApplicable := (status == "OK" || status == "ResourceDiscovered") && !configEvent.EventLeftScope
+	isApplicable := (status == "OK" || status == "ResourceDiscovered") && !r.eventLeftScope
 
-	if isApplicable && len(restrictResourceTypes) > 0 {
-		if _, found := restrictResourceTypes[resourceType]; !found {
-			fmt.Printf("resourceType=%s missing from parameter ResourceTypes\n", resourceType)
+	if isApplicable && len(r.resourceTypes) > 0 {
+		if _, found := r.resourceTypes[resourceType]; !found {
+			log.info("resource type missing from parameter ResourceTypes", logStage, "evaluate")
 			isApplicable = false
 		}
 	}
 
-	if isApplicable && forceNonCompliance {
+	if isApplicable && r.forceNonCompliance {
 		isApplicable = false
-		compliance = configservice.ComplianceTypeNonCompliant
-		annotation = "non-compliance forced by rule parameter ForceNonCompliance"
+		res.compliance = configservice.ComplianceTypeNonCompliant
+		res.annotation = "non-compliance forced by rule parameter ForceNonCompliance"
 	}
 
 	if isApplicable {
-		compliance, annotation = eval(clientConf.s3, configItem, bucket, resourceId, dumpConfigItem)
-		if annotation != "" {
-			fmt.Println(annotation)
+		if sources, errKey := r.baselineSources(ctx, item); errKey != nil {
+			res = result{compliance: configservice.ComplianceTypeNonCompliant, annotation: errKey.Error()}
+		} else {
+			var errEval error
+			res, errEval = eval(ctx, newStore(clientConf, r.baseline), item, sources, r)
+			if errEval != nil {
+				return res, fmt.Errorf("evaluation failed: resourceType=%s resourceId=%s: %v", resourceType, resourceId, errEval)
+			}
 		}
 	}
 
+	if errCtx := ctx.Err(); errCtx != nil {
+		return res, fmt.Errorf("evaluation abandoned: resourceType=%s resourceId=%s: %v", resourceType, resourceId, errCtx)
+	}
+
 	// Send evaluation result
 
-	if dumpConfigItem {
-		fmt.Printf("configuration item compliance: %s\n", compliance)
+	metricsFrom(ctx).count(metricEvaluations, dimComplianceType, string(res.compliance), 1)
+
+	if res.drift.found() {
+		metricsFrom(ctx).count(metricDriftedResources, dimResourceType, resourceType, 1)
+		metricsFrom(ctx).count(metricOffenses, dimResourceType, resourceType, len(res.drift))
+		log.info("evaluation", logStage, "evaluate", logOutcome, res.compliance, "annotation", res.summary(), "offenses", res.drift)
+	} else {
+		log.info("evaluation", logStage, "evaluate", logOutcome, res.compliance, "annotation", res.summary())
+	}
+
+	if annotation := res.summary(); len(annotation) > maxAnnotation {
+		log.debug("annotation truncated", logStage, "submit", "annotation", annotation, "length", len(annotation))
 	}
 
-	sendEval(clientConf.config, configEvent.ResultToken, resourceType, resourceId, t, compliance, annotation)
+	sub.add(newEvaluation(resourceType, resourceId, timestamp, res))
 
-	if compliance == configservice.ComplianceTypeNonCompliant && topicArn != "" {
-		sendSns(clientConf.sns, configEvent.ConfigRuleName, resourceType, resourceId, annotation, topicArn, compliance)
+	if res.compliance == configservice.ComplianceTypeNonCompliant && r.topicArn != "" {
+		if errSns := sendSns(ctx, clientConf.SNS, r.name, resourceType, resourceId, r.topicArn, res); errSns != nil {
+			// evaluation is queued: report alert failure without abandoning it
+			return res, fmt.Errorf("alert failed: resourceType=%s resourceId=%s: %v", resourceType, resourceId, errSns)
+		}
 	}
 
-	return
+	return res, nil
+}
+
+// workContext: ctx ending before the Lambda deadline in ctx, if any.
+// Reserves 10% of remaining time, at most 2s, to report results.
+func workContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline {
+		return context.WithCancel(ctx)
+	}
+	reserve := time.Until(deadline) / 10
+	if reserve > 2*time.Second {
+		reserve = 2 * time.Second
+	}
+	return context.WithDeadline(ctx, deadline.Add(-reserve))
 }
 
+// itemToMap: history item as event payload, SDK field names with lowercase first letter.
+// Maps holding user keys, like tag names, keep their keys as they are.
 func itemToMap(item configservice.ConfigurationItem) (map[string]interface{}, error) {
 
 	itemBuf, errMarshal := json.Marshal(item)
@@ -241,9 +305,24 @@ func itemToMap(item configservice.ConfigurationItem) (map[string]interface{}, er
 
 	mapLow := mapKeyDownRecursive(itemMap)
 
+	if item.Tags != nil {
+		mapLow["tags"] = stringMap(item.Tags)
+	}
+	if item.SupplementaryConfiguration != nil {
+		mapLow["supplementaryConfiguration"] = stringMap(item.SupplementaryConfiguration)
+	}
+
 	return mapLow, nil
 }
 
+func stringMap(m map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
 func mapKeyDownRecursive(m map[string]interface{}) map[string]interface{} {
 	mapLow := map[string]interface{}{}
 	for k, v := range m {
@@ -285,7 +364,9 @@ func mapKeyDownRecursiveSlice(s []interface{}) []interface{} {
 	return sliceLow
 }
 
-func getHistory(configClient *configservice.Client, resourceType, resourceId string) (configservice.ConfigurationItem, error) {
+func getHistory(ctx context.Context, configClient ConfigAPI, resourceType, resourceId string) (configservice.ConfigurationItem, error) {
+
+	log := loggerFrom(ctx).with(logStage, "history", logResourceType, resourceType, logResourceId, resourceId)
 
 	one := int64(1)
 
@@ -295,30 +376,34 @@ func getHistory(configClient *configservice.Client, resourceType, resourceId str
 		ResourceType: configservice.ResourceType(resourceType),
 	}
 
-	req := configClient.GetResourceConfigHistoryRequest(&params)
-	resp, errHistory := req.Send(context.TODO())
-	if errHistory == nil {
-		fmt.Println("ResourceConfigHistory ok: ", resp)
-	} else {
-		fmt.Println("ResourceConfigHistory error: ", errHistory)
+	var resp *configservice.GetResourceConfigHistoryOutput
+	errHistory := retry(ctx, "GetResourceConfigHistory", func() error {
+		var errCall error
+		resp, errCall = configClient.GetResourceConfigHistory(ctx, &params)
+		return errCall
+	})
+	if errHistory != nil {
+		log.warn("GetResourceConfigHistory", logOutcome, "failed", logError, errHistory)
+		return configservice.ConfigurationItem{}, errHistory
 	}
 
+	log.debug("GetResourceConfigHistory", logOutcome, "ok", "items", len(resp.ConfigurationItems))
+
 	if len(resp.ConfigurationItems) < 1 {
 		return configservice.ConfigurationItem{}, fmt.Errorf("ResourceConfigHistory: no config items")
 	}
 
-	return resp.ConfigurationItems[0], errHistory
+	return resp.ConfigurationItems[0], nil
 }
 
-func sendSns(snsClient *sns.Client, ruleName, resourceType, resourceId, annotation, topicArn string, compliance configservice.ComplianceType) {
+// sendSns: alert non-compliance, retrying throttled calls
+func sendSns(ctx context.Context, snsClient SNSAPI, ruleName, resourceType, resourceId, topicArn string, res result) error {
 
-	if annotation == "" {
-		annotation = "[empty annotation]"
-	}
+	log := loggerFrom(ctx).with(logStage, "alert", "topicArn", topicArn)
 
-	sub := fmt.Sprintf("Non-compliance: %s %s %s", ruleName, resourceType, resourceId)
+	annotation := res.message()
 
-	fmt.Printf("SNS subject: [%s]\n", sub)
+	sub := fmt.Sprintf("Non-compliance: %s %s %s", ruleName, resourceType, resourceId)
 
 	params := sns.PublishInput{
 		Subject:  &sub,
@@ -326,394 +411,136 @@ func sendSns(snsClient *sns.Client, ruleName, resourceType, resourceId, annotati
 		TopicArn: &topicArn,
 	}
 
-	req := snsClient.PublishRequest(&params)
-	resp, errSns := req.Send(context.TODO())
-	if errSns == nil {
-		fmt.Println("PublishRequest ok: ", resp)
-	} else {
-		fmt.Println("PublishRequest error: ", errSns)
-	}
-}
-
-// eval: compare item against target
-func eval(s3Client *s3.Client, configItem map[string]interface{}, bucket, resourceId string, dump bool) (configservice.ComplianceType, string) {
-
-	// Fetch target configuration
-
-	target, errTarget := fetch(s3Client, bucket, resourceId)
-	if errTarget != nil {
-		return configservice.ComplianceTypeNonCompliant, fmt.Sprintf("fetch: bucket=%s key=%s %v", bucket, resourceId, errTarget)
-	}
-
-	if dump {
-		logItem("dump config item target: ", target)
-	}
-
-	if offense, annotation := findOffenseMap("", configItem, target, dump); offense {
-		return configservice.ComplianceTypeNonCompliant, annotation
-	}
-
-	return configservice.ComplianceTypeCompliant, ""
-}
-
-func findOffenseMap(path string, item, target map[string]interface{}, dump bool) (bool, string) {
-
-	verbose := false
-
-	if verbose {
-		keys := []string{}
-		for k := range target {
-			keys = append(keys, k)
+	// correlation id leads from alert to the invocation logs
+	if id, isStr := log.fields[logCorrelationId].(string); isStr {
+		params.MessageAttributes = map[string]sns.MessageAttributeValue{
+			logCorrelationId: {DataType: aws.String("String"), StringValue: aws.String(id)},
 		}
-		fmt.Printf("findOffenseMap: path=%s keys=%s\n", path, strings.Join(keys, ","))
 	}
 
-	key := 0
-
-LOOP:
-	for tk, tv := range target {
-		iv, foundKey := item[tk]
-		if !foundKey {
-			return true, fmt.Sprintf("path=[%s] key=%s missing key on item", path, tk)
-		}
-
-		child := path + "." + tk
-
-		key++
-		if verbose {
-			fmt.Printf("findOffenseMap: path=%s %d/%d\n", child, key, len(target))
-		}
-
-		// encoded?
-		tvj, tvString := tv.(string)
-		if verbose {
-			fmt.Printf("findOffenseMap: path=%s target_value_is_string=%v\n", child, tvString)
-		}
-		if tvString {
-			isJ := isJSON(tvj)
-			if verbose {
-				fmt.Printf("findOffenseMap: path=%s target_value_is_json=%v\n", child, isJ)
-			}
-			if isJ {
-				var j interface{}
-				if errJson := json.Unmarshal([]byte(tvj), &j); errJson != nil {
-					return true, fmt.Sprintf("path=[%s] key=%s target bad json: %v", path, tk, errJson)
-				}
-				if offense, annotation := findOffense(child, iv, j, dump); offense {
-					return true, annotation
-				}
-			} else {
-				// scalar?
-				if offense, annotation := findOffenseScalar(child, iv, tvj, verbose); offense {
-					return true, annotation
-				}
-			}
-			continue LOOP // no offense found
-		}
-
-		// map?
-		tvm, tvMap := tv.(map[string]interface{})
-		if verbose {
-			fmt.Printf("findOffenseMap: path=%s target_value_is_map=%v\n", child, tvMap)
-		}
-		if tvMap {
-			ivm, ivMap := iv.(map[string]interface{})
-			if !ivMap {
-				return true, fmt.Sprintf("path=[%s] key=%s item non-map value: %v", path, tk, iv)
-			}
-			if offense, annotation := findOffenseMap(child, ivm, tvm, dump); offense {
-				return true, annotation
-			}
-			continue LOOP // no offense found
-		}
-
-		// slice?
-		tvSlice, tvIsSlice := tv.([]interface{})
-		if verbose {
-			fmt.Printf("findOffenseMap: path=%s target_value_is_slice=%v\n", child, tvIsSlice)
-		}
-		if tvIsSlice {
-			ivSlice, ivIsSlice := iv.([]interface{})
-			if !ivIsSlice {
-				return true, fmt.Sprintf("path=[%s] key=%s item non-slice value: %v", path, tk, iv)
-			}
-			if offense, annotation := findOffenseSlice(child, ivSlice, tvSlice, dump); offense {
-				return true, annotation
-			}
-			continue LOOP // no offense found
-		}
-
-		if verbose {
-			fmt.Printf("findOffenseMap: path=%s target_value_is_scalar\n", child)
-		}
+	var resp *sns.PublishOutput
+	errSns := retry(ctx, "Publish", func() error {
+		var errCall error
+		resp, errCall = snsClient.Publish(ctx, &params)
+		return errCall
+	})
+	if errSns != nil {
+		log.warn("Publish", logOutcome, "failed", "subject", sub, logError, errSns)
+		return fmt.Errorf("Publish: %v", errSns)
+	}
 
-		// scalar?
-		if offense, annotation := findOffenseScalar(child, iv, tv, verbose); offense {
-			return true, annotation
-		}
+	var messageId string
+	if resp.MessageId != nil {
+		messageId = *resp.MessageId
 	}
+	log.info("Publish", logOutcome, "ok", "subject", sub, "messageId", messageId)
 
-	return false, "" // no offense found
+	return nil
 }
 
-func isJSON(str string) bool {
-	var js json.RawMessage
-	return json.Unmarshal([]byte(str), &js) == nil
+// result: outcome of evaluating a config item
+type result struct {
+	compliance configservice.ComplianceType
+	annotation string // reason not related to drift, like fetch error
+	drift      drift
 }
 
-func findOffenseScalar(path string, item, target interface{}, dump bool) (bool, string) {
-	offense, annotation := offenseScalar(path, item, target)
-	if dump {
-		fmt.Printf("findOffenseScalar: path=%s item=%v target=%v offense=%v annotation=%v\n", path, item, target, offense, annotation)
+// summary: single-line annotation for config evaluation
+func (r result) summary() string {
+	if r.drift.found() {
+		return r.drift.annotation()
 	}
-	return offense, annotation
+	return r.annotation
 }
 
-func offenseScalar(path string, item, target interface{}) (bool, string) {
-	tvs, errTv := scalarString(target)
-	if errTv != nil {
-		return true, fmt.Sprintf("path=[%s] target value: %v", path, errTv)
-	}
-	ivs, errIv := scalarString(item)
-	if errIv != nil {
-		return true, fmt.Sprintf("path=[%s] targetScalarValue=%v item value: %v", path, tvs, errIv)
-	}
-	if tvs != ivs {
-		if matchNumber(path, tvs, ivs) {
-			return false, ""
-		}
-		if matchTime(path, tvs, ivs) {
-			return false, ""
+// message: full report for alerts, with drift both as text and json
+func (r result) message() string {
+	if !r.drift.found() {
+		if r.annotation == "" {
+			return "[empty annotation]"
 		}
-		return true, fmt.Sprintf("path=[%s] value mismatch: targetValue=%s itemValue=%s", path, tvs, ivs)
-	}
-
-	return false, ""
-}
-
-func matchNumber(path string, s1, s2 string) bool {
-	f1, errFloat1 := strconv.ParseFloat(s1, 64)
-	if errFloat1 != nil {
-		return false
+		return r.annotation
 	}
-	f2, errFloat2 := strconv.ParseFloat(s2, 64)
-	if errFloat2 != nil {
-		return false
+	msg := fmt.Sprintf("%d offenses:\n%s", len(r.drift), r.drift.report())
+	buf, errJson := json.MarshalIndent(r.drift, "", "  ")
+	if errJson != nil {
+		return msg
 	}
-	return f1 == f2
+	return msg + "\n" + string(buf) + "\n"
 }
 
-func matchTime(path string, s1, s2 string) bool {
-	return timeAndUnix(path, s1, s2) || timeAndUnix(path, s2, s1)
-}
+// eval: compare item against target resolved from documents in store.
+// Transient store failures, like throttling, are returned as error instead of result.
+func eval(ctx context.Context, store BaselineStore, item configurationItem, sources []baselineSource, r rule) (result, error) {
 
-func timeAndUnix(path string, s1, s2 string) bool {
-	//fmt.Printf("path=[%s] timeAndUnix: %s x %s\n", path, s1, s2)
-	t1, errTime := time.Parse(time.RFC3339, s1)
-	if errTime != nil {
-		//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v\n", path, s1, s2, errTime)
-		return false
-	}
-	f, errFloat := strconv.ParseFloat(s2, 64)
-	if errFloat != nil {
-		//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v\n", path, s1, s2, errFloat)
-		return false
-	}
-	t2 := time.Unix(int64(f), 0)
-	result := t1.Equal(t2)
-	//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v x %v: %v\n", path, s1, s2, t1, t2, result)
-	return result
+	log := loggerFrom(ctx).with(logStage, "compare")
 
-}
+	// Fetch target configuration
 
-func findOffenseSlice(path string, item, target []interface{}, dump bool) (bool, string) {
-	if len(item) != len(target) {
-		return true, fmt.Sprintf("path=[%s] slice size mismatch: target=%d item=%d", path, len(target), len(item))
-	}
-	for i, t := range target {
-		it := item[i]
-		child := path + "." + fmt.Sprint(i)
-		if offense, annotation := findOffense(child, it, t, dump); offense {
-			return true, annotation
+	resolved, errTarget := resolveBaseline(ctx, store, sources)
+	if errTarget != nil {
+		if isTransient(errTarget) {
+			return result{}, errTarget
 		}
+		if isNotFound(errTarget) {
+			return missingBaseline(ctx, store, item, sources[len(sources)-1].key, r)
+		}
+		return result{
+			compliance: configservice.ComplianceTypeNonCompliant,
+			annotation: fmt.Sprintf("fetch: %v", errTarget),
+		}, nil
 	}
-	return false, ""
-}
 
-// interface => string => json => map
-func decodeStrJsonMap(i interface{}) (map[string]interface{}, bool) {
-	s, str := i.(string)
-	if !str {
-		return nil, false
-	}
-	isJ := isJSON(s)
-	if !isJ {
-		return nil, false
-	}
-	m := map[string]interface{}{}
-	if errJson := json.Unmarshal([]byte(s), &m); errJson != nil {
-		return nil, false
-	}
-	return m, true
-}
+	used := strings.Join(resolved.used, " ")
 
-func findOffense(path string, item, target interface{}, dump bool) (bool, string) {
-	tm, tMap := target.(map[string]interface{})
-	if tMap {
-		im, iMap := item.(map[string]interface{})
-		if !iMap {
-			im, iMap = decodeStrJsonMap(item) // try to decode string
-			if !iMap {
-				return true, fmt.Sprintf("path=[%s] target is map, item is not", path)
-			}
-		}
-		return findOffenseMap(path, im, tm, dump)
+	log.debug("baseline documents", "documents", resolved.used)
+
+	rules, target, errRules := splitBaseline(resolved.target)
+	if errRules != nil {
+		return result{
+			compliance: configservice.ComplianceTypeNonCompliant,
+			annotation: fmt.Sprintf("baseline: %s: %v", used, errRules),
+		}, nil
 	}
 
-	ts, tSlice := target.([]interface{})
-	if tSlice {
-		is, iSlice := item.([]interface{})
-		if !iSlice {
-			return true, fmt.Sprintf("path=[%s] target is slice, item is not", path)
-		}
-		return findOffenseSlice(path, is, ts, dump)
+	if log.enabled(levelDebug) {
+		log.debug("target", "target", target)
 	}
 
-	return findOffenseScalar(path, item, target, dump)
-}
+	c := comparator{rules: rules, log: log}
 
-func scalarString(v interface{}) (string, error) {
-	if v == nil {
-		return "", nil
-	}
-	s, str := v.(string)
-	if str {
-		return s, nil
-	}
-	i64, isInt64 := v.(int64)
-	if isInt64 {
-		return fmt.Sprint(i64), nil
-	}
-	f32, isF32 := v.(float32)
-	if isF32 {
-		return fmt.Sprint(f32), nil
-	}
-	f64, isF64 := v.(float64)
-	if isF64 {
-		return fmt.Sprint(f64), nil
-	}
-	b, isBool := v.(bool)
-	if isBool {
-		return fmt.Sprint(b), nil
+	begin := time.Now()
+	d := c.compare(item.Payload, target)
+	metricsFrom(ctx).latency(metricComparisonLatency, "", "", time.Since(begin))
+
+	if d.found() {
+		resolved.attribute(d)
+		return result{compliance: configservice.ComplianceTypeNonCompliant, drift: d}, nil
 	}
-	return "", fmt.Errorf("non-nil/string/int/float/bool: %v", v)
+
+	return result{compliance: configservice.ComplianceTypeCompliant}, nil
 }
 
-func sendEval(config *configservice.Client, resultToken, resourceType, resourceId string, timestamp time.Time, compliance configservice.ComplianceType, annotation string) {
+// maxAnnotation: Evaluation annotation length limit
+const maxAnnotation = 255
+
+// newEvaluation: evaluation for config service, with annotation truncated to API limit
+func newEvaluation(resourceType, resourceId string, timestamp time.Time, res result) configservice.Evaluation {
+	compliance := res.compliance
+	annotation := res.summary()
 	var ann *string
 	if annotation != "" {
-		if len(annotation) > 255 {
-			fmt.Printf("truncating annotation to 255-char: %s\n", annotation)
-			annotation = annotation[:255]
+		if len(annotation) > maxAnnotation {
+			annotation = annotation[:maxAnnotation]
 		}
 		ann = &annotation
 	}
 
-	eval := configservice.Evaluation{
+	return configservice.Evaluation{
 		Annotation:             ann,
 		ComplianceResourceType: &resourceType,
 		ComplianceResourceId:   &resourceId,
 		ComplianceType:         compliance,
 		OrderingTimestamp:      &timestamp,
 	}
-	report := configservice.PutEvaluationsInput{
-		ResultToken: &resultToken,
-		Evaluations: []configservice.Evaluation{eval},
-	}
-	req := config.PutEvaluationsRequest(&report)
-	resp, errPut := req.Send(context.TODO())
-	if errPut == nil {
-		fmt.Println("PutEvaluations ok: ", resp)
-	} else {
-		fmt.Println("PutEvaluations error: ", errPut)
-	}
-}
-
-func fetch(client *s3.Client, bucket, resourceId string) (map[string]interface{}, error) {
-
-	var key string
-
-	list := strings.SplitN(bucket, "/", 2)
-	if len(list) < 2 {
-		key = resourceId
-	} else {
-		key = list[1] + "/" + resourceId
-	}
-
-	params := &s3.GetObjectInput{
-		Bucket: aws.String(list[0]), // Required
-		Key:    aws.String(key),     // Required
-	}
-
-	req := client.GetObjectRequest(params)
-	resp, errSend := req.Send(context.TODO())
-	if errSend != nil {
-		return nil, errSend
-	}
-
-	buf, errRead := ioutil.ReadAll(resp.Body)
-	if errRead != nil {
-		return nil, errRead
-	}
-
-	item := map[string]interface{}{}
-	if errJson := json.Unmarshal(buf, &item); errJson != nil {
-		return nil, errJson
-	}
-
-	return item, nil
-}
-
-func logItem(prefix string, configItem map[string]interface{}) {
-	for k, v := range configItem {
-		fmt.Printf("%s %s = %v\n", prefix, k, v)
-	}
-}
-
-func mapString(m map[string]interface{}, key string) string {
-	v, found := m[key]
-	if found {
-		s, isStr := v.(string)
-		if isStr {
-			return s
-		}
-	}
-	return ""
-}
-
-type conf struct {
-	cfg    aws.Config
-	config *configservice.Client
-	s3     *s3.Client
-	sns    *sns.Client
-}
-
-func getConfig() *conf {
-
-	cfg, err := external.LoadDefaultAWSConfig()
-	if err != nil {
-		fmt.Printf("getConfig: %v\n", err)
-		return nil
-	}
-
-	cfg.Region = endpoints.SaEast1RegionID
-
-	c := conf{
-		cfg:    cfg,
-		config: configservice.New(cfg),
-		s3:     s3.New(cfg),
-		sns:    sns.New(cfg),
-	}
-
-	return &c
 }
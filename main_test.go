@@ -3,9 +3,14 @@ package main_test
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
 
 	"github.com/udhos/aws-config-lambda"
 )
@@ -28,20 +33,31 @@ func TestHandler(t *testing.T) {
 		err     bool
 	}{
 		{
-			request: events.ConfigEvent{InvokingEvent: invoke, ConfigRuleName: "non-empty"},
-			expect:  "ok",
-			err:     false,
+			request: events.ConfigEvent{InvokingEvent: invoke, ConfigRuleName: "non-empty", RuleParameters: testParams},
+			expect:  "InvokingEvent: messageType: missing",
+			err:     true,
 		},
 		{
 			request: events.ConfigEvent{InvokingEvent: invoke},
-			expect:  "ok",
-			err:     false,
+			expect:  "RuleParameters: Bucket: missing (or Baseline)",
+			err:     true,
+		},
+		{
+			request: events.ConfigEvent{InvokingEvent: invoke, RuleParameters: `{"Buckett":"baselines"}`},
+			expect:  "RuleParameters: Buckett: unknown key (expected one of: Baseline, Bucket, CaptureCompliance, CaptureExclude, Dump, ForceNonCompliance, Groups, KeyTemplate, LogLevel, MissingBaseline, ResourceTypes, TopicArn); Bucket: missing (or Baseline)",
+			err:     true,
+		},
+		{
+			request: events.ConfigEvent{InvokingEvent: invoke, RuleParameters: `{"Bucket":"baselines",`},
+			expect:  "RuleParameters: unexpected end of JSON input",
+			err:     true,
 		},
 	}
 
 	for _, test := range tests {
 		ctx := context.Background()
-		response, err := main.Handler(ctx, test.request)
+		h := main.NewHandler(main.Clients{Config: &main.FakeConfig{}, S3: &main.FakeS3{}, SNS: &main.FakeSNS{}})
+		response, err := h.Handle(ctx, test.request)
 		if response.Str != test.expect {
 			t.Errorf("response request=%v expected=[%s] got=[%s]", test.request, test.expect, response.Str)
 		}
@@ -51,3 +67,264 @@ func TestHandler(t *testing.T) {
 	}
 
 }
+
+const (
+	testBucket = "baselines"
+	testTopic  = "arn:aws:sns:sa-east-1:123456789012:drift"
+	testParams = `{"Bucket":"baselines","TopicArn":"arn:aws:sns:sa-east-1:123456789012:drift","ResourceTypes":"AWS::EC2::Instance"}`
+)
+
+func historyItem(resourceId, configuration string) configservice.ConfigurationItem {
+	capture := time.Date(2019, 6, 10, 14, 21, 7, 0, time.UTC)
+	return configservice.ConfigurationItem{
+		ConfigurationItemStatus:      configservice.ConfigurationItemStatusOk,
+		ResourceType:                 configservice.ResourceTypeAwsEc2Instance,
+		ResourceId:                   aws.String(resourceId),
+		ConfigurationItemCaptureTime: &capture,
+		Configuration:                aws.String(configuration),
+	}
+}
+
+func changeEvent(resourceId, instanceType string) string {
+	return `{"messageType":"ConfigurationItemChangeNotification","configurationItem":{` +
+		`"configurationItemStatus":"OK","resourceType":"AWS::EC2::Instance","resourceId":"` + resourceId + `",` +
+		`"configurationItemCaptureTime":"2019-06-10T14:21:07.000Z",` +
+		`"configuration":{"instanceType":"` + instanceType + `"}}}`
+}
+
+func TestHandlerPipeline(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		params     string // default testParams
+		invoke     string
+		expect     string
+		err        bool
+		compliance []configservice.ComplianceType // per resource, in evaluation order
+		alerts     int
+		captured   string // s3 object expected to be created
+	}{
+		{
+			name:       "change compliant",
+			invoke:     changeEvent("i-1", "t2.micro"),
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeCompliant},
+		},
+		{
+			name:       "change drift",
+			invoke:     changeEvent("i-1", "t2.large"),
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeNonCompliant},
+			alerts:     1,
+		},
+		{
+			name:       "change missing baseline",
+			invoke:     changeEvent("i-3", "t2.micro"),
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeNonCompliant},
+			alerts:     1,
+		},
+		{
+			name:       "change missing baseline not applicable",
+			params:     `{"Bucket":"baselines","TopicArn":"` + testTopic + `","MissingBaseline":"NOT_APPLICABLE"}`,
+			invoke:     changeEvent("i-3", "t2.micro"),
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeNotApplicable},
+		},
+		{
+			name:       "change missing baseline capture",
+			params:     `{"Bucket":"baselines","TopicArn":"` + testTopic + `","MissingBaseline":"CAPTURE"}`,
+			invoke:     changeEvent("i-3", "t2.micro"),
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeNotApplicable},
+			captured:   testBucket + "/AWS::EC2::Instance/i-3",
+		},
+		{
+			name:       "oversized from history",
+			invoke:     `{"messageType":"OversizedConfigurationItemChangeNotification","configurationItemSummary":{"resourceType":"AWS::EC2::Instance","resourceId":"i-2"}}`,
+			expect:     "ok",
+			compliance: []configservice.ComplianceType{configservice.ComplianceTypeNonCompliant},
+			alerts:     1,
+		},
+		{
+			name:       "oversized missing history",
+			invoke:     `{"messageType":"OversizedConfigurationItemChangeNotification","configurationItemSummary":{"resourceType":"AWS::EC2::Instance","resourceId":"i-9"}}`,
+			expect:     "getHistory:",
+			err:        true,
+			compliance: nil,
+		},
+		{
+			name:   "scheduled",
+			invoke: `{"messageType":"ScheduledNotification","notificationCreationTime":"2019-06-11T00:00:00.000Z"}`,
+			expect: "ScheduledNotification: resources=2 compliant=1 non_compliant=1",
+			compliance: []configservice.ComplianceType{
+				configservice.ComplianceTypeCompliant,
+				configservice.ComplianceTypeNonCompliant,
+			},
+			alerts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		config := &main.FakeConfig{
+			Items: []configservice.ConfigurationItem{
+				historyItem("i-1", `{"instanceType":"t2.micro"}`),
+				historyItem("i-2", `{"instanceType":"t2.large"}`),
+			},
+		}
+		s3 := &main.FakeS3{
+			Objects: map[string]string{
+				testBucket + "/AWS::EC2::Instance/i-1": `{"configuration":{"instanceType":"t2.micro"}}`,
+				testBucket + "/AWS::EC2::Instance/i-2": `{"configuration":"{\"instanceType\":\"t2.micro\"}"}`,
+			},
+		}
+		sns := &main.FakeSNS{}
+
+		h := main.NewHandler(main.Clients{Config: config, S3: s3, SNS: sns})
+
+		params := test.params
+		if params == "" {
+			params = testParams
+		}
+
+		request := events.ConfigEvent{
+			ConfigRuleName: "drift",
+			InvokingEvent:  test.invoke,
+			ResultToken:    "token",
+			RuleParameters: params,
+		}
+
+		response, err := h.Handle(context.Background(), request)
+		if !strings.HasPrefix(response.Str, test.expect) {
+			t.Errorf("%s: response expected=[%s] got=[%s]", test.name, test.expect, response.Str)
+		}
+		if (err != nil) != test.err {
+			t.Errorf("%s: error expected=%v got=%v", test.name, test.err, err)
+		}
+		if test.captured != "" {
+			if _, found := s3.Objects[test.captured]; !found {
+				t.Errorf("%s: missing captured baseline: %s", test.name, test.captured)
+			}
+		}
+		if len(config.Evaluations) != len(test.compliance) {
+			t.Errorf("%s: evaluations expected=%d got=%d", test.name, len(test.compliance), len(config.Evaluations))
+			continue
+		}
+		for i, e := range config.Evaluations {
+			if e.ComplianceType != test.compliance[i] {
+				t.Errorf("%s: evaluation %d resource=%s expected=%s got=%s annotation=%s", test.name, i, *e.ComplianceResourceId, test.compliance[i], e.ComplianceType, aws.StringValue(e.Annotation))
+			}
+		}
+		if len(sns.Published) != test.alerts {
+			t.Errorf("%s: alerts expected=%d got=%d", test.name, test.alerts, len(sns.Published))
+		}
+		for _, p := range sns.Published {
+			if *p.TopicArn != testTopic {
+				t.Errorf("%s: alert topic expected=%s got=%s", test.name, testTopic, *p.TopicArn)
+			}
+		}
+	}
+}
+
+func TestHandlerRetry(t *testing.T) {
+
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	denied := awserr.New("AuthorizationError", "not authorized", nil)
+
+	tests := []struct {
+		name        string
+		timeout     time.Duration // invocation deadline, default none
+		putErrs     []error
+		publishErrs []error
+		expect      string
+		err         bool
+		putCalls    int
+		publish     int // Publish calls
+		evaluations int
+	}{
+		{name: "put throttled then ok", putErrs: []error{throttled, throttled}, expect: "ok", putCalls: 3, publish: 1, evaluations: 1},
+		{name: "put throttled exhausted", putErrs: []error{throttled, throttled, throttled}, expect: "PutEvaluations", err: true, putCalls: 3, publish: 1},
+		{name: "put not retryable", putErrs: []error{denied}, expect: "PutEvaluations", err: true, putCalls: 1, publish: 1},
+		// first backoff (at least 100ms) does not fit within the deadline
+		{name: "put throttled past deadline", timeout: 80 * time.Millisecond, putErrs: []error{throttled, throttled}, err: true, putCalls: 1, publish: 1},
+		{name: "publish throttled then ok", publishErrs: []error{throttled, throttled}, expect: "ok", putCalls: 1, publish: 3, evaluations: 1},
+		{name: "publish failed", publishErrs: []error{denied}, expect: "alert failed", err: true, putCalls: 1, publish: 1, evaluations: 1},
+	}
+
+	for _, test := range tests {
+		config := &main.FakeConfig{PutErrs: test.putErrs}
+		s3 := &main.FakeS3{Objects: map[string]string{testBucket + "/AWS::EC2::Instance/i-1": `{"configuration":{"instanceType":"t2.micro"}}`}}
+		sns := &main.FakeSNS{PublishErrs: test.publishErrs}
+
+		h := main.NewHandler(main.Clients{Config: config, S3: s3, SNS: sns})
+
+		ctx := context.Background()
+		if test.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, test.timeout)
+			defer cancel()
+		}
+
+		request := events.ConfigEvent{
+			ConfigRuleName: "drift",
+			InvokingEvent:  changeEvent("i-1", "t2.large"),
+			ResultToken:    "token",
+			RuleParameters: testParams,
+		}
+
+		begin := time.Now()
+		response, err := h.Handle(ctx, request)
+		elapsed := time.Since(begin)
+
+		if !strings.Contains(response.Str, test.expect) {
+			t.Errorf("%s: response expected=[%s] got=[%s]", test.name, test.expect, response.Str)
+		}
+		if (err != nil) != test.err {
+			t.Errorf("%s: error expected=%v got=%v", test.name, test.err, err)
+		}
+		if len(config.PutCalls) != test.putCalls {
+			t.Errorf("%s: PutEvaluations calls expected=%d got=%d", test.name, test.putCalls, len(config.PutCalls))
+		}
+		if sns.PublishCalls != test.publish {
+			t.Errorf("%s: Publish calls expected=%d got=%d", test.name, test.publish, sns.PublishCalls)
+		}
+		if len(config.Evaluations) != test.evaluations {
+			t.Errorf("%s: evaluations expected=%d got=%d", test.name, test.evaluations, len(config.Evaluations))
+		}
+		if test.timeout > 0 && elapsed > test.timeout {
+			t.Errorf("%s: handler ran past deadline: %v", test.name, elapsed)
+		}
+	}
+}
+
+func TestHandlerScheduledTags(t *testing.T) {
+
+	// tag and supplementary configuration keys from history keep their case
+	item := historyItem("i-1", `{"instanceType":"t2.micro"}`)
+	item.Tags = map[string]string{"Name": "web"}
+	item.SupplementaryConfiguration = map[string]string{"ServiceName": "ec2"}
+
+	config := &main.FakeConfig{Items: []configservice.ConfigurationItem{item}}
+	s3 := &main.FakeS3{Objects: map[string]string{
+		testBucket + "/AWS::EC2::Instance/i-1": `{"tags":{"Name":"web"},"supplementaryConfiguration":{"ServiceName":"ec2"},"configuration":{"instanceType":"t2.micro"}}`,
+	}}
+
+	h := main.NewHandler(main.Clients{Config: config, S3: s3, SNS: &main.FakeSNS{}})
+
+	request := events.ConfigEvent{
+		ConfigRuleName: "drift",
+		InvokingEvent:  `{"messageType":"ScheduledNotification","notificationCreationTime":"2019-06-11T00:00:00.000Z"}`,
+		ResultToken:    "token",
+		RuleParameters: testParams,
+	}
+
+	if _, err := h.Handle(context.Background(), request); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if len(config.Evaluations) != 1 {
+		t.Fatalf("evaluations: %v", config.Evaluations)
+	}
+	if e := config.Evaluations[0]; e.ComplianceType != configservice.ComplianceTypeCompliant {
+		t.Errorf("expected COMPLIANT, got %s annotation=%s", e.ComplianceType, aws.StringValue(e.Annotation))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Matchers: target values expressing policy instead of literal values.
+// A target map is a matcher when all its keys start with "$":
+//
+//	"imageName":    {"$regex": "^amzn2-ami-hvm-"}
+//	"volumeSize":   {"$min": 8, "$max": 100}
+//	"instanceType": {"$oneOf": ["t3.micro", "t3.small"]}
+//	"keyName":      {"$nonEmpty": true}
+//	"ebsOptimized": {"$type": "boolean", "$eq": true}
+//
+// All matchers in the map must hold.
+const (
+	matchRegex    = "$regex"    // item string matches regular expression
+	matchMin      = "$min"      // item number >= value
+	matchMax      = "$max"      // item number <= value
+	matchOneOf    = "$oneOf"    // item equals one of the listed scalars
+	matchNonEmpty = "$nonEmpty" // item is a non-empty string
+	matchType     = "$type"     // item json type: string, number, boolean, null, map, slice
+	matchEq       = "$eq"       // item equals scalar value
+)
+
+// isMatcher: non-empty map holding only "$" keys
+func isMatcher(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// findOffenseMatcher: report every failing matcher
+func findOffenseMatcher(path string, item interface{}, matcher map[string]interface{}) drift {
+	var d drift
+	names := make([]string, 0, len(matcher))
+	for k := range matcher {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		arg := matcher[name]
+		ok, errMatch := evalMatcher(name, arg, item)
+		if errMatch != nil {
+			d = append(d, offense{Path: path, Kind: kindBadTarget, Expected: fmt.Sprintf("%s: %v", name, errMatch), Actual: valueString(item)})
+			continue
+		}
+		if !ok {
+			d = append(d, offense{Path: path, Kind: kindMatcherMismatch, Expected: name + "=" + valueString(arg), Actual: valueString(item)})
+		}
+	}
+	return d
+}
+
+func evalMatcher(name string, arg, item interface{}) (bool, error) {
+	switch name {
+	case matchRegex:
+		pattern, isStr := arg.(string)
+		if !isStr {
+			return false, fmt.Errorf("non-string pattern: %v", arg)
+		}
+		re, errCompile := regexp.Compile(pattern)
+		if errCompile != nil {
+			return false, errCompile
+		}
+		s, errScalar := scalarString(item)
+		if errScalar != nil {
+			return false, nil
+		}
+		return re.MatchString(s), nil
+	case matchMin, matchMax:
+		limit, errLimit := numberValue(arg)
+		if errLimit != nil {
+			return false, errLimit
+		}
+		n, errNum := numberValue(item)
+		if errNum != nil {
+			return false, nil
+		}
+		if name == matchMin {
+			return n >= limit, nil
+		}
+		return n <= limit, nil
+	case matchOneOf:
+		list, isSlice := arg.([]interface{})
+		if !isSlice {
+			return false, fmt.Errorf("non-list value: %v", arg)
+		}
+		for _, v := range list {
+			if equalScalar(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case matchNonEmpty:
+		want, isBool := arg.(bool)
+		if !isBool {
+			return false, fmt.Errorf("non-boolean value: %v", arg)
+		}
+		s, isStr := item.(string)
+		return (isStr && s != "") == want, nil
+	case matchType:
+		t, isStr := arg.(string)
+		if !isStr {
+			return false, fmt.Errorf("non-string type: %v", arg)
+		}
+		if t == "boolean" {
+			t = "bool"
+		}
+		switch t {
+		case "string", "number", "bool", "null", "map", "slice":
+		default:
+			return false, fmt.Errorf("unknown type: %s", t)
+		}
+		return typeName(item) == t, nil
+	case matchEq:
+		if _, errScalar := scalarString(arg); errScalar != nil {
+			return false, fmt.Errorf("non-scalar value: %v", arg)
+		}
+		return equalScalar(arg, item), nil
+	}
+	return false, fmt.Errorf("unknown matcher")
+}
+
+func numberValue(v interface{}) (float64, error) {
+	s, errScalar := scalarString(v)
+	if errScalar != nil {
+		return 0, errScalar
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// equalScalar: scalar equality, with numeric comparison for numbers
+func equalScalar(target, item interface{}) bool {
+	ts, errTarget := scalarString(target)
+	if errTarget != nil {
+		return false
+	}
+	is, errItem := scalarString(item)
+	if errItem != nil {
+		return false
+	}
+	return ts == is || matchNumber("", ts, is)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+
+	tests := []struct {
+		target string
+		item   string
+		report string
+	}{
+		{
+			target: `{"name":{"$regex":"^amzn2-ami-"}}`,
+			item:   `{"name":"amzn2-ami-hvm-2.0"}`,
+			report: "",
+		},
+		{
+			target: `{"name":{"$regex":"^amzn2-ami-"}}`,
+			item:   `{"name":"ubuntu-18.04"}`,
+			report: "path=[name] matcher mismatch: target=$regex=^amzn2-ami- item=ubuntu-18.04\n",
+		},
+		{
+			target: `{"size":{"$min":8,"$max":100}}`,
+			item:   `{"size":"50"}`,
+			report: "",
+		},
+		{
+			target: `{"size":{"$min":8,"$max":100}}`,
+			item:   `{"size":200}`,
+			report: "path=[size] matcher mismatch: target=$max=100 item=200\n",
+		},
+		{
+			target: `{"type":{"$oneOf":["t3.micro","t3.small"]}}`,
+			item:   `{"type":"t3.small"}`,
+			report: "",
+		},
+		{
+			target: `{"type":{"$oneOf":["t3.micro","t3.small"]}}`,
+			item:   `{"type":"m5.large"}`,
+			report: `path=[type] matcher mismatch: target=$oneOf=["t3.micro","t3.small"] item=m5.large` + "\n",
+		},
+		{
+			target: `{"key":{"$nonEmpty":true}}`,
+			item:   `{"key":""}`,
+			report: "path=[key] matcher mismatch: target=$nonEmpty=true item=\n",
+		},
+		{
+			target: `{"ebs":{"$type":"boolean","$eq":true}}`,
+			item:   `{"ebs":true}`,
+			report: "",
+		},
+		{
+			target: `{"ebs":{"$type":"boolean","$eq":true}}`,
+			item:   `{"ebs":"true"}`,
+			report: "path=[ebs] matcher mismatch: target=$type=boolean item=true\n",
+		},
+		{
+			target: `{"c":"{\"s\":[{\"$regex\":\"^sg-\"}]}"}`,
+			item:   `{"c":"{\"s\":[\"sg-123\"]}"}`,
+			report: "", // matcher inside slice inside json-encoded string
+		},
+		{
+			target: `{"name":{"$regex":"("}}`,
+			item:   `{"name":"x"}`,
+			report: "path=[name] bad target: target=$regex: error parsing regexp: missing closing ): `(` item=x\n",
+		},
+		{
+			target: `{"name":{"$like":"x"}}`,
+			item:   `{"name":"x"}`,
+			report: "path=[name] bad target: target=$like: unknown matcher item=x\n",
+		},
+	}
+
+	for _, test := range tests {
+		tm := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.target), &tm); err != nil {
+			t.Errorf("bad json target=%v %v", test.target, err)
+		}
+		im := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.item), &im); err != nil {
+			t.Errorf("bad json item=%v %v", test.item, err)
+		}
+		d := comparator{}.compare(im, tm)
+		if report := d.report(); report != test.report {
+			t.Errorf("report mismatch: target=%v item=%v\nexpected:\n%s\nfound:\n%s", test.target, test.item, test.report, report)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// metricsNamespace: CloudWatch namespace of metrics in Embedded Metric Format
+const metricsNamespace = "aws-config-lambda"
+
+// Metric names
+const (
+	metricEvaluations       = "Evaluations"       // by ComplianceType
+	metricDriftedResources  = "DriftedResources"  // by ResourceType
+	metricOffenses          = "Offenses"          // by ResourceType
+	metricBaselineMissing   = "BaselineMissing"   // resources evaluated without baseline document
+	metricComparisonLatency = "ComparisonLatency" // milliseconds comparing item against target
+	metricAPIErrors         = "APIErrors"         // by Operation
+)
+
+// Dimensions. Every metric has dimension ConfigRuleName, plus at most one of the others.
+const (
+	dimRule           = "ConfigRuleName"
+	dimComplianceType = "ComplianceType"
+	dimResourceType   = "ResourceType"
+	dimOperation      = "Operation"
+)
+
+// Metric units
+const (
+	unitCount        = "Count"
+	unitMilliseconds = "Milliseconds"
+)
+
+// maxMetricValues: CloudWatch rejects EMF metrics with more values than this in a single line
+const maxMetricValues = 100
+
+// metricKey: metric name and its dimension besides ConfigRuleName
+type metricKey struct {
+	name     string
+	unit     string
+	dimName  string // empty: only ConfigRuleName
+	dimValue string
+}
+
+// metrics: values recorded during invocation, written as EMF lines by flush.
+// Methods on nil metrics do nothing, so code runs without metrics outside Lambda.
+type metrics struct {
+	rule   string
+	out    io.Writer // nil: os.Stdout at the time of flush
+	mutex  sync.Mutex
+	values map[metricKey][]float64
+}
+
+func newMetrics(rule string) *metrics {
+	return &metrics{rule: rule, values: map[metricKey][]float64{}}
+}
+
+// count: add n to counter name, with optional dimension
+func (m *metrics) count(name, dimName, dimValue string, n int) {
+	m.record(metricKey{name: name, unit: unitCount, dimName: dimName, dimValue: dimValue}, float64(n), true)
+}
+
+// latency: record one duration sample for name, with optional dimension
+func (m *metrics) latency(name, dimName, dimValue string, d time.Duration) {
+	m.record(metricKey{name: name, unit: unitMilliseconds, dimName: dimName, dimValue: dimValue}, milliseconds(d), false)
+}
+
+// record: counters are summed into a single value, other metrics keep every sample
+func (m *metrics) record(key metricKey, value float64, sum bool) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if sum && len(m.values[key]) == 1 {
+		m.values[key][0] += value
+		return
+	}
+	m.values[key] = append(m.values[key], value)
+}
+
+// flush: write one EMF line for each set of dimension values, then forget recorded values.
+// Metrics with more than maxMetricValues samples, like ComparisonLatency in a
+// periodic evaluation of many resources, are split across further lines.
+func (m *metrics) flush() {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	values := m.values
+	m.values = map[metricKey][]float64{}
+	m.mutex.Unlock()
+
+	if len(values) == 0 {
+		return
+	}
+
+	// group metrics sharing dimension values into the same line
+	type dimension struct{ name, value string }
+	groups := map[dimension][]metricKey{}
+	var dims []dimension
+	for k := range values {
+		d := dimension{k.dimName, k.dimValue}
+		if _, found := groups[d]; !found {
+			dims = append(dims, d)
+		}
+		groups[d] = append(groups[d], k)
+	}
+	sort.Slice(dims, func(i, j int) bool {
+		if dims[i].name != dims[j].name {
+			return dims[i].name < dims[j].name
+		}
+		return dims[i].value < dims[j].value
+	})
+
+	out := m.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for _, d := range dims {
+		keys := groups[d]
+		sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+
+		for offset := 0; ; offset += maxMetricValues {
+			if !m.writeLine(out, timestamp, d.name, d.value, keys, values, offset) {
+				break
+			}
+		}
+	}
+}
+
+// writeLine: write EMF line with values[offset:offset+maxMetricValues] of keys.
+// Returns false when no key has values past offset, so nothing was written.
+func (m *metrics) writeLine(out io.Writer, timestamp int64, dimName, dimValue string, keys []metricKey, values map[metricKey][]float64, offset int) bool {
+	dimensionSet := []string{dimRule}
+	line := map[string]interface{}{dimRule: m.rule}
+	if dimName != "" {
+		dimensionSet = append(dimensionSet, dimName)
+		line[dimName] = dimValue
+	}
+
+	var definitions []map[string]string
+	for _, k := range keys {
+		v := values[k]
+		if offset >= len(v) {
+			continue
+		}
+		v = v[offset:]
+		if len(v) > maxMetricValues {
+			v = v[:maxMetricValues]
+		}
+		definitions = append(definitions, map[string]string{"Name": k.name, "Unit": k.unit})
+		if len(v) == 1 {
+			line[k.name] = v[0]
+		} else {
+			line[k.name] = v
+		}
+	}
+	if len(definitions) == 0 {
+		return false
+	}
+
+	line["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  metricsNamespace,
+			"Dimensions": [][]string{dimensionSet},
+			"Metrics":    definitions,
+		}},
+	}
+
+	buf, errJson := json.Marshal(line)
+	if errJson != nil {
+		return true
+	}
+
+	logMutex.Lock()
+	fmt.Fprintln(out, string(buf))
+	logMutex.Unlock()
+
+	return true
+}
+
+type metricsKey struct{}
+
+// withMetrics: ctx carrying m for functions called during invocation
+func withMetrics(ctx context.Context, m *metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// metricsFrom: metrics in ctx, or nil
+func metricsFrom(ctx context.Context) *metrics {
+	m, _ := ctx.Value(metricsKey{}).(*metrics)
+	return m
+}
+
+// expectedCodes: AWS error codes reporting normal outcomes, like a missing baseline, not counted as API errors
+var expectedCodes = map[string]bool{
+	s3.ErrCodeNoSuchKey:                             true,
+	ssm.ErrCodeParameterNotFound:                    true,
+	s3ErrCodePreconditionFailed:                     true,
+	ssm.ErrCodeParameterAlreadyExists:               true,
+	dynamodb.ErrCodeConditionalCheckFailedException: true,
+}
+
+// apiError: count failed call of AWS operation
+func apiError(ctx context.Context, operation string, err error) {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if awsErr, isAws := err.(awserr.Error); isAws && expectedCodes[awsErr.Code()] {
+		return
+	}
+	metricsFrom(ctx).count(metricAPIErrors, dimOperation, operation, 1)
+}
+
+// apiObserver: receives outcome of every AWS call made through instrumented clients
+type apiObserver func(ctx context.Context, operation string, err error)
+
+// instrument: clients reporting the outcome of every call to observe, like apiError
+func instrument(c *Clients, observe apiObserver) *Clients {
+	return &Clients{
+		Config:   configMetrics{c.Config, observe},
+		S3:       s3Metrics{c.S3, observe},
+		SNS:      snsMetrics{c.SNS, observe},
+		SSM:      ssmMetrics{c.SSM, observe},
+		DynamoDB: dynamoMetrics{c.DynamoDB, observe},
+	}
+}
+
+type configMetrics struct {
+	ConfigAPI
+	observe apiObserver
+}
+
+func (c configMetrics) GetResourceConfigHistory(ctx context.Context, input *configservice.GetResourceConfigHistoryInput) (*configservice.GetResourceConfigHistoryOutput, error) {
+	resp, err := c.ConfigAPI.GetResourceConfigHistory(ctx, input)
+	c.observe(ctx, "GetResourceConfigHistory", err)
+	return resp, err
+}
+
+func (c configMetrics) ListDiscoveredResources(ctx context.Context, input *configservice.ListDiscoveredResourcesInput) (*configservice.ListDiscoveredResourcesOutput, error) {
+	resp, err := c.ConfigAPI.ListDiscoveredResources(ctx, input)
+	c.observe(ctx, "ListDiscoveredResources", err)
+	return resp, err
+}
+
+func (c configMetrics) PutEvaluations(ctx context.Context, input *configservice.PutEvaluationsInput) (*configservice.PutEvaluationsOutput, error) {
+	resp, err := c.ConfigAPI.PutEvaluations(ctx, input)
+	c.observe(ctx, "PutEvaluations", err)
+	return resp, err
+}
+
+func (c configMetrics) SelectResourceConfig(ctx context.Context, input *configservice.SelectResourceConfigInput) (*configservice.SelectResourceConfigOutput, error) {
+	resp, err := c.ConfigAPI.SelectResourceConfig(ctx, input)
+	c.observe(ctx, "SelectResourceConfig", err)
+	return resp, err
+}
+
+type s3Metrics struct {
+	S3API
+	observe apiObserver
+}
+
+func (c s3Metrics) GetObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	resp, err := c.S3API.GetObject(ctx, input)
+	c.observe(ctx, "GetObject", err)
+	return resp, err
+}
+
+func (c s3Metrics) PutObject(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	resp, err := c.S3API.PutObject(ctx, input)
+	c.observe(ctx, "PutObject", err)
+	return resp, err
+}
+
+func (c s3Metrics) PutObjectIfAbsent(ctx context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	resp, err := c.S3API.PutObjectIfAbsent(ctx, input)
+	c.observe(ctx, "PutObject", err)
+	return resp, err
+}
+
+type snsMetrics struct {
+	SNSAPI
+	observe apiObserver
+}
+
+func (c snsMetrics) Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	resp, err := c.SNSAPI.Publish(ctx, input)
+	c.observe(ctx, "Publish", err)
+	return resp, err
+}
+
+type ssmMetrics struct {
+	SSMAPI
+	observe apiObserver
+}
+
+func (c ssmMetrics) GetParameter(ctx context.Context, input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	resp, err := c.SSMAPI.GetParameter(ctx, input)
+	c.observe(ctx, "GetParameter", err)
+	return resp, err
+}
+
+func (c ssmMetrics) PutParameter(ctx context.Context, input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	resp, err := c.SSMAPI.PutParameter(ctx, input)
+	c.observe(ctx, "PutParameter", err)
+	return resp, err
+}
+
+type dynamoMetrics struct {
+	DynamoDBAPI
+	observe apiObserver
+}
+
+func (c dynamoMetrics) GetItem(ctx context.Context, input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	resp, err := c.DynamoDBAPI.GetItem(ctx, input)
+	c.observe(ctx, "GetItem", err)
+	return resp, err
+}
+
+func (c dynamoMetrics) PutItem(ctx context.Context, input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	resp, err := c.DynamoDBAPI.PutItem(ctx, input)
+	c.observe(ctx, "PutItem", err)
+	return resp, err
+}
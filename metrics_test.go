@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestMetricsFlush(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMetrics("drift")
+	m.out = &buf
+
+	m.count(metricEvaluations, dimComplianceType, "COMPLIANT", 1)
+	m.count(metricEvaluations, dimComplianceType, "COMPLIANT", 1)
+	m.count(metricEvaluations, dimComplianceType, "NON_COMPLIANT", 1)
+	m.count(metricOffenses, dimResourceType, "AWS::EC2::Instance", 3)
+	m.count(metricDriftedResources, dimResourceType, "AWS::EC2::Instance", 1)
+	m.count(metricBaselineMissing, "", "", 1)
+	m.latency(metricComparisonLatency, "", "", 2*time.Millisecond)
+	m.latency(metricComparisonLatency, "", "", 4*time.Millisecond)
+	m.flush()
+
+	lines := decodeEvents(t, &buf)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), buf.String())
+	}
+
+	// lines sorted by dimension: none, ComplianceType values, ResourceType
+	plain := lines[0]
+	if plain[dimRule] != "drift" || plain[metricBaselineMissing] != 1.0 {
+		t.Errorf("line without dimension: %v", plain)
+	}
+	if samples, isSlice := plain[metricComparisonLatency].([]interface{}); !isSlice || len(samples) != 2 || samples[0] != 2.0 {
+		t.Errorf("latency samples: %v", plain[metricComparisonLatency])
+	}
+	if lines[1][dimComplianceType] != "COMPLIANT" || lines[1][metricEvaluations] != 2.0 {
+		t.Errorf("compliant evaluations: %v", lines[1])
+	}
+	if lines[2][dimComplianceType] != "NON_COMPLIANT" || lines[2][metricEvaluations] != 1.0 {
+		t.Errorf("non-compliant evaluations: %v", lines[2])
+	}
+	if lines[3][metricOffenses] != 3.0 || lines[3][metricDriftedResources] != 1.0 {
+		t.Errorf("drift by resource type: %v", lines[3])
+	}
+
+	emf, _ := lines[3]["_aws"].(map[string]interface{})
+	if _, hasTimestamp := emf["Timestamp"].(float64); !hasTimestamp {
+		t.Errorf("missing timestamp: %v", emf)
+	}
+	directives, _ := emf["CloudWatchMetrics"].([]interface{})
+	if len(directives) != 1 {
+		t.Fatalf("directives: %v", emf)
+	}
+	d := directives[0].(map[string]interface{})
+	if d["Namespace"] != metricsNamespace {
+		t.Errorf("namespace: %v", d["Namespace"])
+	}
+	dims, _ := d["Dimensions"].([]interface{})
+	if len(dims) != 1 || len(dims[0].([]interface{})) != 2 || dims[0].([]interface{})[1] != dimResourceType {
+		t.Errorf("dimensions: %v", d["Dimensions"])
+	}
+	if defs, _ := d["Metrics"].([]interface{}); len(defs) != 2 {
+		t.Errorf("metric definitions: %v", d["Metrics"])
+	}
+
+	buf.Reset()
+	m.flush()
+	if buf.Len() != 0 {
+		t.Errorf("values not reset by flush: %s", buf.String())
+	}
+
+	var none *metrics
+	none.count(metricEvaluations, "", "", 1)
+	none.flush()
+}
+
+func TestMetricsFlushChunks(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMetrics("drift")
+	m.out = &buf
+
+	// periodic evaluation of 250 resources
+	for i := 0; i < 250; i++ {
+		m.latency(metricComparisonLatency, "", "", time.Millisecond)
+	}
+	m.count(metricBaselineMissing, "", "", 2)
+	m.flush()
+
+	lines := decodeEvents(t, &buf)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %s", len(lines), buf.String())
+	}
+	var samples int
+	for i, line := range lines {
+		v, _ := line[metricComparisonLatency].([]interface{})
+		if len(v) > maxMetricValues {
+			t.Errorf("line %d: %d values exceed EMF limit", i, len(v))
+		}
+		samples += len(v)
+		emf, _ := line["_aws"].(map[string]interface{})
+		directives, _ := emf["CloudWatchMetrics"].([]interface{})
+		defs, _ := directives[0].(map[string]interface{})["Metrics"].([]interface{})
+		if _, hasCount := line[metricBaselineMissing]; hasCount != (i == 0) || len(defs) != len(line)-2 {
+			t.Errorf("line %d: counter must be written once, with definitions matching values: %v", i, line)
+		}
+	}
+	if samples != 250 {
+		t.Errorf("latency samples: expected=250 got=%d", samples)
+	}
+}
+
+func TestMetricsAPIErrors(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMetrics("drift")
+	m.out = &buf
+	ctx := withMetrics(context.Background(), m)
+
+	clients := instrument(&Clients{S3: &FakeS3{Objects: map[string]string{"bucket/key": "{}"}}}, apiError)
+
+	get := func() {
+		clients.S3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	}
+
+	get() // ok
+	// NoSuchKey: missing baseline, not an error
+	clients.S3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("missing")})
+	clients.S3 = instrument(&Clients{S3: &FakeS3{GetErr: awserr.New("SlowDown", "slow down", nil)}}, apiError).S3
+	get()
+	get()
+	apiError(ctx, "PutEvaluations", context.DeadlineExceeded)
+
+	m.flush()
+
+	lines := decodeEvents(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0][dimOperation] != "GetObject" || lines[0][metricAPIErrors] != 2.0 {
+		t.Errorf("api errors: %v", lines[0])
+	}
+}
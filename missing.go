@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// Values of parameter MissingBaseline: outcome for a resource without baseline
+const (
+	missingNonCompliant  = "NON_COMPLIANT"  // default: report resource as non-compliant
+	missingNotApplicable = "NOT_APPLICABLE" // ignore resource until its baseline is created
+	missingCapture       = "CAPTURE"        // store current item as baseline
+)
+
+// captureDocument: baseline from item, without excluded fields, recording who captured it and when
+func captureDocument(item configurationItem, exclude [][]string, by string) ([]byte, error) {
+	doc := excludePaths(item.Payload, exclude)
+
+	doc[baselineKey] = baselineRules{
+		Captured: &captureInfo{
+			By:          by,
+			At:          time.Now().UTC().Format(time.RFC3339),
+			CaptureTime: item.CaptureTime.UTC().Format(time.RFC3339),
+		},
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// capturedBy: rule and function capturing baselines
+func capturedBy(r rule) string {
+	by := "aws-config-lambda rule=" + r.name
+	if function := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); function != "" {
+		by += " function=" + function
+	}
+	return by
+}
+
+// missingBaseline: result for resource whose baseline documents were not found.
+// Only store failures are returned as error, never the missing baseline itself.
+func missingBaseline(ctx context.Context, store BaselineStore, item configurationItem, key string, r rule) (result, error) {
+
+	location := store.Location(key)
+
+	metricsFrom(ctx).count(metricBaselineMissing, "", "", 1)
+
+	switch r.missing {
+	case missingNotApplicable:
+		return result{
+			compliance: configservice.ComplianceTypeNotApplicable,
+			annotation: fmt.Sprintf("no baseline: %s", location),
+		}, nil
+	case missingCapture:
+		doc, errDoc := captureDocument(item, r.captureExclude, capturedBy(r))
+		if errDoc != nil {
+			return result{
+				compliance: configservice.ComplianceTypeNonCompliant,
+				annotation: fmt.Sprintf("capture: %s: %v", location, errDoc),
+			}, nil
+		}
+		errPut := retryStore(ctx, func() error {
+			return store.Put(ctx, key, doc, false)
+		})
+		if isExists(errPut) {
+			// concurrent evaluation of the same resource captured it first
+			return result{
+				compliance: r.captureCompliance,
+				annotation: fmt.Sprintf("baseline captured by concurrent evaluation: %s", location),
+			}, nil
+		}
+		if errPut != nil {
+			if isTransient(errPut) {
+				return result{}, fmt.Errorf("capture: %v", errPut)
+			}
+			return result{
+				compliance: configservice.ComplianceTypeNonCompliant,
+				annotation: fmt.Sprintf("capture: %v", errPut),
+			}, nil
+		}
+		return result{
+			compliance: r.captureCompliance,
+			annotation: fmt.Sprintf("baseline captured: %s", location),
+		}, nil
+	}
+
+	return result{
+		compliance: configservice.ComplianceTypeNonCompliant,
+		annotation: fmt.Sprintf("no baseline: %s", location),
+	}, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+func TestCapture(t *testing.T) {
+
+	store := &FakeStore{}
+
+	r := rule{name: "drift"}
+	r.keyTemplate = defaultKeyTemplate
+	r.missing = missingCapture
+	r.captureExclude = splitPatterns(defaultCaptureExclude)
+	r.captureCompliance = configservice.ComplianceTypeCompliant
+
+	newItem := func(status, captureTime, instanceType string) configurationItem {
+		payload := map[string]interface{}{
+			"configurationItemStatus":      status,
+			"configurationItemCaptureTime": captureTime,
+			"resourceType":                 "AWS::EC2::Instance",
+			"resourceId":                   "i-1",
+			"configuration":                `{"instanceType":"` + instanceType + `","networkInterfaces":[{"interfaceType":"interface","networkInterfaceId":"eni-1"}]}`,
+		}
+		ct, _ := time.Parse(time.RFC3339, captureTime)
+		return configurationItem{Status: status, ResourceType: "AWS::EC2::Instance", ResourceId: "i-1", CaptureTime: ct, Payload: payload}
+	}
+
+	evaluate := func(item configurationItem) result {
+		res, errEval := eval(context.Background(), store, item, []baselineSource{{key: "AWS::EC2::Instance/i-1"}}, r)
+		if errEval != nil {
+			t.Fatalf("eval: %v", errEval)
+		}
+		return res
+	}
+
+	// first run: capture
+	res := evaluate(newItem("ResourceDiscovered", "2019-06-10T14:21:07Z", "t2.micro"))
+	if res.compliance != configservice.ComplianceTypeCompliant || res.annotation != "baseline captured: fake://AWS::EC2::Instance/i-1" {
+		t.Errorf("capture: unexpected result: %s %s", res.compliance, res.annotation)
+	}
+
+	doc := store.Documents["AWS::EC2::Instance/i-1"]
+	for _, excluded := range []string{"configurationItemStatus", "configurationItemCaptureTime", "interfaceType"} {
+		if strings.Contains(doc, excluded) {
+			t.Errorf("captured baseline holds excluded field %s: %s", excluded, doc)
+		}
+	}
+
+	var captured map[string]interface{}
+	if errJson := json.Unmarshal([]byte(doc), &captured); errJson != nil {
+		t.Fatalf("captured baseline: %v", errJson)
+	}
+	rules, _, errRules := splitBaseline(captured)
+	if errRules != nil {
+		t.Fatalf("captured baseline: %v", errRules)
+	}
+	if rules.Captured == nil || rules.Captured.By != "aws-config-lambda rule=drift" || rules.Captured.At == "" || rules.Captured.CaptureTime != "2019-06-10T14:21:07Z" {
+		t.Errorf("captured baseline: unexpected metadata: %+v", rules.Captured)
+	}
+
+	// next runs: compared against captured baseline
+	if res = evaluate(newItem("OK", "2019-06-11T09:00:00Z", "t2.micro")); res.compliance != configservice.ComplianceTypeCompliant {
+		t.Errorf("unchanged item: expected compliant, got: %s %s %s", res.compliance, res.annotation, res.drift.annotation())
+	}
+	if res = evaluate(newItem("OK", "2019-06-11T10:00:00Z", "t2.large")); res.compliance != configservice.ComplianceTypeNonCompliant {
+		t.Errorf("drifted item: expected non-compliant, got: %s", res.compliance)
+	}
+
+	// concurrent evaluation captured the baseline after this one found none
+	res, errMissing := missingBaseline(context.Background(), store, newItem("ResourceDiscovered", "2019-06-10T14:21:07Z", "t2.micro"), "AWS::EC2::Instance/i-1", r)
+	if errMissing != nil {
+		t.Fatalf("capture race: %v", errMissing)
+	}
+	if res.compliance != configservice.ComplianceTypeCompliant || !strings.Contains(res.annotation, "concurrent") {
+		t.Errorf("capture race: unexpected result: %s %s", res.compliance, res.annotation)
+	}
+	if !strings.Contains(store.Documents["AWS::EC2::Instance/i-1"], "t2.micro") {
+		t.Errorf("capture race: existing baseline replaced")
+	}
+}
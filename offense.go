@@ -0,0 +1,655 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Offense kinds
+const (
+	kindMissingKey        = "missing key"
+	kindMissingElement    = "missing element"
+	kindUnexpectedElement = "unexpected element"
+	kindUnexpectedKey     = "unexpected key"
+	kindTypeMismatch      = "type mismatch"
+	kindValueMismatch     = "value mismatch"
+	kindMatcherMismatch   = "matcher mismatch"
+	kindBadTarget         = "bad target"
+)
+
+// comparator: compare config item against target, following baseline rules
+type comparator struct {
+	rules baselineRules
+	log   logger // debug level traces comparison
+}
+
+// offense: one difference found between config item and target
+type offense struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Source   string `json:"source,omitempty"` // baseline document defining target, if composed from many
+}
+
+func (o offense) String() string {
+	s := fmt.Sprintf("path=[%s] %s: target=%s item=%s", o.Path, o.Kind, o.Expected, o.Actual)
+	if o.Source != "" {
+		s += " source=" + o.Source
+	}
+	return s
+}
+
+// drift: full list of offenses found in a config item
+type drift []offense
+
+func (d drift) found() bool {
+	return len(d) > 0
+}
+
+// annotation: all offenses in a single line
+func (d drift) annotation() string {
+	switch len(d) {
+	case 0:
+		return ""
+	case 1:
+		return d[0].String()
+	}
+	list := make([]string, 0, len(d))
+	for _, o := range d {
+		list = append(list, o.String())
+	}
+	return fmt.Sprintf("%d offenses: %s", len(d), strings.Join(list, "; "))
+}
+
+// report: one offense per line
+func (d drift) report() string {
+	var b strings.Builder
+	for _, o := range d {
+		b.WriteString(o.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func newOffense(path, kind string, target, item interface{}) offense {
+	return offense{Path: path, Kind: kind, Expected: valueString(target), Actual: valueString(item)}
+}
+
+// valueString: render value for offense report
+func valueString(v interface{}) string {
+	if s, errScalar := scalarString(v); errScalar == nil {
+		return s
+	}
+	buf, errJson := json.Marshal(v)
+	if errJson != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "slice"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	}
+	return "number"
+}
+
+func pathKey(path, key string) string {
+	key = escapeKey(key)
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// compare: full drift of item against target, including absent rules
+func (c comparator) compare(item, target map[string]interface{}) drift {
+	d := c.findOffenseMap("", item, target)
+	return append(d, c.findAbsent(item)...)
+}
+
+// findAbsent: report item paths matching absent rules
+func (c comparator) findAbsent(item map[string]interface{}) drift {
+	var d drift
+	for _, pattern := range c.rules.absent {
+		d = append(d, findPresent("", item, pattern)...)
+	}
+	return d
+}
+
+// findPresent: walk value following pattern, reporting every path found
+func findPresent(path string, value interface{}, pattern []string) drift {
+	if len(pattern) == 0 {
+		return drift{newOffense(path, kindUnexpectedKey, "<absent>", value)}
+	}
+
+	var d drift
+	seg, rest := pattern[0], pattern[1:]
+
+	if isIndexSegment(seg) {
+		s, isSlice := value.([]interface{})
+		if !isSlice {
+			return nil
+		}
+		inner := strings.TrimSuffix(strings.TrimPrefix(seg, "["), "]")
+		if inner == "*" {
+			for i, e := range s {
+				d = append(d, findPresent(pathIndex(path, i), e, rest)...)
+			}
+			return d
+		}
+		if eq := strings.IndexByte(inner, '='); eq > 0 {
+			field, want := inner[:eq], inner[eq+1:]
+			for _, e := range s {
+				if k, found := elemKey(e, field); found && k == want {
+					d = append(d, findPresent(pathElemKey(path, field, k), e, rest)...)
+				}
+			}
+			return d
+		}
+		if i, errAtoi := strconv.Atoi(inner); errAtoi == nil && i >= 0 && i < len(s) {
+			return findPresent(pathIndex(path, i), s[i], rest)
+		}
+		return nil
+	}
+
+	m, isMap := value.(map[string]interface{})
+	if !isMap {
+		if m, isMap = decodeStrJsonMap(value); !isMap {
+			return nil
+		}
+	}
+	if seg == "*" {
+		for _, k := range sortedKeys(m) {
+			d = append(d, findPresent(pathKey(path, k), m[k], rest)...)
+		}
+		return d
+	}
+	v, found := m[seg]
+	if !found {
+		return nil
+	}
+	return findPresent(pathKey(path, seg), v, rest)
+}
+
+// sortedKeys: map keys in lexicographic byte order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findOffenseMap: walk target keys in lexicographic byte order (see sortedKeys),
+// so the same drift is always reported with offenses in the same order.
+func (c comparator) findOffenseMap(path string, item, target map[string]interface{}) drift {
+
+	verbose := false
+
+	keys := sortedKeys(target)
+
+	if verbose {
+		c.log.debug("findOffenseMap", "path", path, "keys", keys)
+	}
+
+	var d drift
+
+	key := 0
+
+	for _, tk := range keys {
+		tv := target[tk]
+		child := pathKey(path, tk)
+
+		if c.rules.ignored(child) {
+			continue
+		}
+
+		iv, foundKey := item[tk]
+		if !foundKey {
+			d = append(d, offense{Path: child, Kind: kindMissingKey, Expected: valueString(tv), Actual: "<missing>"})
+			continue
+		}
+
+		key++
+		if verbose {
+			c.log.debug("findOffenseMap", "path", child, "key", key, "keys", len(target))
+		}
+
+		// encoded?
+		tvj, tvString := tv.(string)
+		if verbose {
+			c.log.debug("findOffenseMap", "path", child, "targetValueIsString", tvString)
+		}
+		if tvString {
+			isJ := isJSON(tvj)
+			if verbose {
+				c.log.debug("findOffenseMap", "path", child, "targetValueIsJson", isJ)
+			}
+			if isJ {
+				var j interface{}
+				if errJson := json.Unmarshal([]byte(tvj), &j); errJson != nil {
+					d = append(d, offense{Path: child, Kind: kindBadTarget, Expected: errJson.Error(), Actual: valueString(iv)})
+					continue
+				}
+				d = append(d, c.findOffense(child, iv, j)...)
+			} else {
+				// scalar?
+				d = append(d, c.findOffenseScalar(child, iv, tvj, verbose)...)
+			}
+			continue
+		}
+
+		// map?
+		tvm, tvMap := tv.(map[string]interface{})
+		if verbose {
+			c.log.debug("findOffenseMap", "path", child, "targetValueIsMap", tvMap)
+		}
+		if tvMap && isMatcher(tvm) {
+			d = append(d, c.findOffenseScalar(child, iv, tv, verbose)...)
+			continue
+		}
+		if tvMap {
+			ivm, ivMap := iv.(map[string]interface{})
+			if !ivMap {
+				ivm, ivMap = decodeStrJsonMap(iv) // try to decode string
+			}
+			if !ivMap {
+				d = append(d, offense{Path: child, Kind: kindTypeMismatch, Expected: "map", Actual: typeName(iv)})
+				continue
+			}
+			d = append(d, c.findOffenseMap(child, ivm, tvm)...)
+			continue
+		}
+
+		// slice?
+		tvSlice, tvIsSlice := tv.([]interface{})
+		if verbose {
+			c.log.debug("findOffenseMap", "path", child, "targetValueIsSlice", tvIsSlice)
+		}
+		if tvIsSlice {
+			ivSlice, ivIsSlice := iv.([]interface{})
+			if !ivIsSlice {
+				d = append(d, offense{Path: child, Kind: kindTypeMismatch, Expected: "slice", Actual: typeName(iv)})
+				continue
+			}
+			d = append(d, c.findOffenseSlice(child, ivSlice, tvSlice)...)
+			continue
+		}
+
+		if verbose {
+			c.log.debug("findOffenseMap", "path", child, "targetValueIsScalar", true)
+		}
+
+		// scalar?
+		d = append(d, c.findOffenseScalar(child, iv, tv, verbose)...)
+	}
+
+	return d
+}
+
+func isJSON(str string) bool {
+	var js json.RawMessage
+	return json.Unmarshal([]byte(str), &js) == nil
+}
+
+func (c comparator) findOffenseScalar(path string, item, target interface{}, trace bool) drift {
+	if tm, tMap := target.(map[string]interface{}); tMap && isMatcher(tm) {
+		d := findOffenseMatcher(path, item, tm)
+		if trace {
+			c.log.debug("findOffenseScalar", "path", path, "item", item, "matcher", target, "offenses", len(d), "annotation", d.annotation())
+		}
+		return d
+	}
+	o, found := offenseScalar(path, item, target)
+	if trace {
+		c.log.debug("findOffenseScalar", "path", path, "item", item, "target", target, "offense", found, "annotation", o)
+	}
+	if found {
+		return drift{o}
+	}
+	return nil
+}
+
+func offenseScalar(path string, item, target interface{}) (offense, bool) {
+	tvs, errTv := scalarString(target)
+	if errTv != nil {
+		return offense{Path: path, Kind: kindBadTarget, Expected: errTv.Error(), Actual: valueString(item)}, true
+	}
+	ivs, errIv := scalarString(item)
+	if errIv != nil {
+		return offense{Path: path, Kind: kindTypeMismatch, Expected: tvs, Actual: typeName(item)}, true
+	}
+	if tvs != ivs {
+		if matchNumber(path, tvs, ivs) {
+			return offense{}, false
+		}
+		if matchTime(path, tvs, ivs) {
+			return offense{}, false
+		}
+		return offense{Path: path, Kind: kindValueMismatch, Expected: tvs, Actual: ivs}, true
+	}
+
+	return offense{}, false
+}
+
+func matchNumber(path string, s1, s2 string) bool {
+	f1, errFloat1 := strconv.ParseFloat(s1, 64)
+	if errFloat1 != nil {
+		return false
+	}
+	f2, errFloat2 := strconv.ParseFloat(s2, 64)
+	if errFloat2 != nil {
+		return false
+	}
+	return f1 == f2
+}
+
+func matchTime(path string, s1, s2 string) bool {
+	return timeAndUnix(path, s1, s2) || timeAndUnix(path, s2, s1)
+}
+
+func timeAndUnix(path string, s1, s2 string) bool {
+	//fmt.Printf("path=[%s] timeAndUnix: %s x %s\n", path, s1, s2)
+	t1, errTime := time.Parse(time.RFC3339, s1)
+	if errTime != nil {
+		//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v\n", path, s1, s2, errTime)
+		return false
+	}
+	f, errFloat := strconv.ParseFloat(s2, 64)
+	if errFloat != nil {
+		//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v\n", path, s1, s2, errFloat)
+		return false
+	}
+	t2 := time.Unix(int64(f), 0)
+	result := t1.Equal(t2)
+	//fmt.Printf("path=[%s] timeAndUnix: %s x %s: %v x %v: %v\n", path, s1, s2, t1, t2, result)
+	return result
+
+}
+
+func (c comparator) findOffenseSlice(path string, item, target []interface{}) drift {
+	mode := c.rules.sliceMode(path)
+	if field := c.rules.sliceKey(path); field != "" {
+		return c.findOffenseKeyed(path, field, item, target, mode == sliceSubset)
+	}
+	switch mode {
+	case sliceSet, sliceSubset:
+		return c.findOffenseSet(path, item, target, mode == sliceSubset)
+	}
+	return c.findOffenseOrdered(path, item, target)
+}
+
+func pathElemKey(path, field, value string) string {
+	return fmt.Sprintf("%s[%s=%s]", path, field, value)
+}
+
+// elemKey: identity of slice element under key field
+func elemKey(elem interface{}, field string) (string, bool) {
+	m, isMap := elem.(map[string]interface{})
+	if !isMap {
+		if m, isMap = decodeStrJsonMap(elem); !isMap {
+			return "", false
+		}
+	}
+	v, found := m[field]
+	if !found {
+		return "", false
+	}
+	s, errScalar := scalarString(v)
+	if errScalar != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// findOffenseKeyed: pair target and item elements by the value of key field,
+// regardless of position, then compare paired elements field by field.
+// Unless subset is true, item elements not paired with any target element are
+// reported as unexpected.
+func (c comparator) findOffenseKeyed(path, field string, item, target []interface{}, subset bool) drift {
+	var d drift
+
+	itemIndex := map[string]int{}
+	var unkeyed []int
+	for i, ie := range item {
+		k, found := elemKey(ie, field)
+		if !found {
+			unkeyed = append(unkeyed, i)
+			continue
+		}
+		if _, dup := itemIndex[k]; dup {
+			unkeyed = append(unkeyed, i) // only first element with key is paired
+			continue
+		}
+		itemIndex[k] = i
+	}
+
+	paired := map[string]bool{}
+	for t, te := range target {
+		k, found := elemKey(te, field)
+		if !found {
+			d = append(d, offense{Path: pathIndex(path, t), Kind: kindBadTarget, Expected: fmt.Sprintf("element without key field %s", field), Actual: "<none>"})
+			continue
+		}
+		child := pathElemKey(path, field, k)
+		if c.rules.ignored(child) {
+			paired[k] = true
+			continue
+		}
+		i, foundItem := itemIndex[k]
+		if !foundItem || paired[k] {
+			d = append(d, newOffense(child, kindMissingElement, te, "<missing>"))
+			continue
+		}
+		paired[k] = true
+		d = append(d, c.findOffense(child, item[i], te)...)
+	}
+
+	if subset {
+		return d
+	}
+
+	for _, k := range sortedIndexKeys(itemIndex) {
+		if !paired[k] && !c.rules.ignored(pathElemKey(path, field, k)) {
+			d = append(d, newOffense(pathElemKey(path, field, k), kindUnexpectedElement, "<none>", item[itemIndex[k]]))
+		}
+	}
+	for _, i := range unkeyed {
+		d = append(d, newOffense(pathIndex(path, i), kindUnexpectedElement, "<none>", item[i]))
+	}
+
+	return d
+}
+
+func sortedIndexKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findOffenseOrdered: compare slices element by element, by index
+func (c comparator) findOffenseOrdered(path string, item, target []interface{}) drift {
+	var d drift
+	for i, t := range target {
+		child := pathIndex(path, i)
+		if c.rules.ignored(child) {
+			continue
+		}
+		if i >= len(item) {
+			d = append(d, newOffense(child, kindMissingElement, t, "<missing>"))
+			continue
+		}
+		d = append(d, c.findOffense(child, item[i], t)...)
+	}
+	for i := len(target); i < len(item); i++ {
+		child := pathIndex(path, i)
+		if c.rules.ignored(child) {
+			continue
+		}
+		d = append(d, newOffense(child, kindUnexpectedElement, "<none>", item[i]))
+	}
+	return d
+}
+
+// findOffenseSet: every target element must match a distinct item element,
+// regardless of position. Unless subset is true, unmatched item elements are
+// reported as unexpected.
+func (c comparator) findOffenseSet(path string, item, target []interface{}, subset bool) drift {
+
+	// match[t] holds item indexes that fully match target element t
+	match := make([][]int, len(target))
+	for t, te := range target {
+		for i, ie := range item {
+			if !c.findOffense(pathIndex(path, i), ie, te).found() {
+				match[t] = append(match[t], i)
+			}
+		}
+	}
+
+	itemOwner := assignSet(match, len(item))
+
+	matched := make([]bool, len(target))
+	for _, t := range itemOwner {
+		if t >= 0 {
+			matched[t] = true
+		}
+	}
+
+	var d drift
+	for t, te := range target {
+		if !matched[t] {
+			d = append(d, newOffense(pathIndex(path, t), kindMissingElement, te, "<missing>"))
+		}
+	}
+	if !subset {
+		for i, t := range itemOwner {
+			if t < 0 {
+				d = append(d, newOffense(pathIndex(path, i), kindUnexpectedElement, "<none>", item[i]))
+			}
+		}
+	}
+	return d
+}
+
+// assignSet: maximum bipartite matching between target elements and item elements.
+// match[t] lists item indexes acceptable for target t.
+// Returns, for each item index, the target index it was assigned to, or -1.
+func assignSet(match [][]int, itemSize int) []int {
+	itemOwner := make([]int, itemSize)
+	for i := range itemOwner {
+		itemOwner[i] = -1
+	}
+
+	var augment func(t int, seen []bool) bool
+	augment = func(t int, seen []bool) bool {
+		for _, i := range match[t] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			if itemOwner[i] < 0 || augment(itemOwner[i], seen) {
+				itemOwner[i] = t
+				return true
+			}
+		}
+		return false
+	}
+
+	for t := range match {
+		augment(t, make([]bool, itemSize))
+	}
+
+	return itemOwner
+}
+
+// interface => string => json => map
+func decodeStrJsonMap(i interface{}) (map[string]interface{}, bool) {
+	s, str := i.(string)
+	if !str {
+		return nil, false
+	}
+	isJ := isJSON(s)
+	if !isJ {
+		return nil, false
+	}
+	m := map[string]interface{}{}
+	if errJson := json.Unmarshal([]byte(s), &m); errJson != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+func (c comparator) findOffense(path string, item, target interface{}) drift {
+	tm, tMap := target.(map[string]interface{})
+	if tMap && !isMatcher(tm) {
+		im, iMap := item.(map[string]interface{})
+		if !iMap {
+			im, iMap = decodeStrJsonMap(item) // try to decode string
+			if !iMap {
+				return drift{{Path: path, Kind: kindTypeMismatch, Expected: "map", Actual: typeName(item)}}
+			}
+		}
+		return c.findOffenseMap(path, im, tm)
+	}
+
+	ts, tSlice := target.([]interface{})
+	if tSlice {
+		is, iSlice := item.([]interface{})
+		if !iSlice {
+			return drift{{Path: path, Kind: kindTypeMismatch, Expected: "slice", Actual: typeName(item)}}
+		}
+		return c.findOffenseSlice(path, is, ts)
+	}
+
+	return c.findOffenseScalar(path, item, target, c.log.enabled(levelDebug))
+}
+
+func scalarString(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	s, str := v.(string)
+	if str {
+		return s, nil
+	}
+	i64, isInt64 := v.(int64)
+	if isInt64 {
+		return fmt.Sprint(i64), nil
+	}
+	f32, isF32 := v.(float32)
+	if isF32 {
+		return fmt.Sprint(f32), nil
+	}
+	f64, isF64 := v.(float64)
+	if isF64 {
+		return fmt.Sprint(f64), nil
+	}
+	b, isBool := v.(bool)
+	if isBool {
+		return fmt.Sprint(b), nil
+	}
+	return "", fmt.Errorf("non-nil/string/int/float/bool: %v", v)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -29,17 +30,290 @@ func TestOffenseMap(t *testing.T) {
 			item:    map[string]interface{}{"tags": map[string]interface{}{"key1": "value2"}},
 			offense: true,
 		},
+		{
+			// configuration from resource config history is a JSON string
+			target:  map[string]interface{}{"configuration": map[string]interface{}{"instanceType": "t2.micro"}},
+			item:    map[string]interface{}{"configuration": `{"instanceType":"t2.micro"}`},
+			offense: false,
+		},
+		{
+			target:  map[string]interface{}{"configuration": map[string]interface{}{"instanceType": "t2.micro"}},
+			item:    map[string]interface{}{"configuration": `{"instanceType":"t2.large"}`},
+			offense: true,
+		},
+		{
+			target:  map[string]interface{}{"configuration": map[string]interface{}{"instanceType": "t2.micro"}},
+			item:    map[string]interface{}{"configuration": "t2.micro"},
+			offense: true,
+		},
+	}
+
+	log := logger{} // INFO level: set level to levelDebug to trace comparison
+
+	for _, test := range tests {
+		d := comparator{log: log}.findOffenseMap("", test.item, test.target)
+		o, annotation := d.found(), d.annotation()
+		if o != test.offense {
+			t.Errorf("offenseExpected=%v offenseFound=%v annotation=%s target=%v item=%v", test.offense, o, annotation, test.target, test.item)
+		}
+	}
+
+}
+
+func TestOffenseAll(t *testing.T) {
+
+	target := `{"a":"1","b":{"c":"2","d":"3"},"e":["4","5"],"f":"6"}`
+	item := `{"a":"0","b":{"c":"0","d":"3"},"e":["4"]}`
+
+	expected := map[string]string{
+		"a":    kindValueMismatch,
+		"b.c":  kindValueMismatch,
+		"e[1]": kindMissingElement,
+		"f":    kindMissingKey,
+	}
+
+	tm := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(target), &tm); err != nil {
+		t.Errorf("bad json target=%v %v", target, err)
+	}
+	im := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(item), &im); err != nil {
+		t.Errorf("bad json item=%v %v", item, err)
+	}
+
+	d := comparator{}.findOffenseMap("", im, tm)
+	if len(d) != len(expected) {
+		t.Errorf("offensesExpected=%d offensesFound=%d report:\n%s", len(expected), len(d), d.report())
+	}
+	for _, o := range d {
+		kind, found := expected[o.Path]
+		if !found {
+			t.Errorf("unexpected offense: %v", o)
+			continue
+		}
+		if kind != o.Kind {
+			t.Errorf("path=%s kindExpected=%s kindFound=%s", o.Path, kind, o.Kind)
+		}
+	}
+}
+
+func TestOffenseOrder(t *testing.T) {
+
+	target := map[string]interface{}{}
+	item := map[string]interface{}{}
+	for _, k := range []string{"z", "b", "y", "a", "x", "c"} {
+		target[k] = "target"
+		item[k] = "item"
 	}
 
-	dump := false
+	expected := "a,b,c,x,y,z"
+
+	for i := 0; i < 20; i++ {
+		var paths []string
+		for _, o := range (comparator{}).findOffenseMap("", item, target) {
+			paths = append(paths, o.Path)
+		}
+		if result := strings.Join(paths, ","); result != expected {
+			t.Errorf("order mismatch: expected:%s result:%s", expected, result)
+			return
+		}
+	}
+}
+
+func TestOffenseSet(t *testing.T) {
+
+	tests := []struct {
+		target  string
+		item    string
+		offense bool
+	}{
+		{
+			target:  `{"s":["a","b"]}`,
+			item:    `{"s":["b","a"]}`,
+			offense: true, // ordered by default
+		},
+		{
+			target:  `{"$baseline":{"slices":{"s":"set"}},"s":["a","b"]}`,
+			item:    `{"s":["b","a"]}`,
+			offense: false,
+		},
+		{
+			target:  `{"$baseline":{"slices":{"s":"set"}},"s":["a","b"]}`,
+			item:    `{"s":["b","a","c"]}`,
+			offense: true, // extra item element
+		},
+		{
+			target:  `{"$baseline":{"slices":{"s":"subset"}},"s":["a","b"]}`,
+			item:    `{"s":["b","c","a"]}`,
+			offense: false,
+		},
+		{
+			target:  `{"$baseline":{"slices":{"s":"subset"}},"s":["a","a"]}`,
+			item:    `{"s":["a","b"]}`,
+			offense: true, // each item element matches a single target element
+		},
+		{
+			target:  `{"$baseline":{"slices":{"s":"set"}},"s":[{"k":"1"},{"k":"1","v":"2"}]}`,
+			item:    `{"s":[{"k":"1","v":"2"},{"k":"1","v":"3"}]}`,
+			offense: false, // first target element must not take the only match for the second one
+		},
+		{
+			target:  `{"$baseline":{"slices":{"c.s":"set"}},"c":"{\"s\":[1,2]}"}`,
+			item:    `{"c":"{\"s\":[2,1]}"}`,
+			offense: false, // slice inside json-encoded string
+		},
+	}
 
 	for _, test := range tests {
-		o, annotation := findOffenseMap("", test.item, test.target, dump)
+		tm := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.target), &tm); err != nil {
+			t.Errorf("bad json target=%v %v", test.target, err)
+		}
+		im := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.item), &im); err != nil {
+			t.Errorf("bad json item=%v %v", test.item, err)
+		}
+		rules, tm, errRules := splitBaseline(tm)
+		if errRules != nil {
+			t.Errorf("bad baseline rules target=%v %v", test.target, errRules)
+		}
+		d := comparator{rules: rules}.findOffenseMap("", im, tm)
+		o, annotation := d.found(), d.annotation()
 		if o != test.offense {
 			t.Errorf("offenseExpected=%v offenseFound=%v annotation=%s target=%v item=%v", test.offense, o, annotation, test.target, test.item)
 		}
 	}
+}
+
+func TestOffenseKeyed(t *testing.T) {
+
+	tests := []struct {
+		target string
+		item   string
+		report string
+	}{
+		{
+			target: `{"$baseline":{"keys":{"n":"id"}},"n":[{"id":"a","v":"1"},{"id":"b","v":"2"}]}`,
+			item:   `{"n":[{"id":"b","v":"2"},{"id":"a","v":"1"}]}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"keys":{"n":"id"}},"n":[{"id":"a","v":"1"},{"id":"b","v":"2"}]}`,
+			item:   `{"n":[{"id":"c","v":"3"},{"id":"a","v":"0"}]}`,
+			report: "path=[n[id=a].v] value mismatch: target=1 item=0\n" +
+				`path=[n[id=b]] missing element: target={"id":"b","v":"2"} item=<missing>` + "\n" +
+				`path=[n[id=c]] unexpected element: target=<none> item={"id":"c","v":"3"}` + "\n",
+		},
+		{
+			target: `{"$baseline":{"keys":{"n":"id"},"slices":{"n":"subset"}},"n":[{"id":"a","v":"1"}]}`,
+			item:   `{"n":[{"id":"c","v":"3"},{"id":"a","v":"1"}]}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"keys":{"n":"id"}},"n":[{"v":"1"}]}`,
+			item:   `{"n":[]}`,
+			report: "path=[n[0]] bad target: target=element without key field id item=<none>\n",
+		},
+	}
+
+	for _, test := range tests {
+		tm := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.target), &tm); err != nil {
+			t.Errorf("bad json target=%v %v", test.target, err)
+		}
+		im := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.item), &im); err != nil {
+			t.Errorf("bad json item=%v %v", test.item, err)
+		}
+		rules, tm, errRules := splitBaseline(tm)
+		if errRules != nil {
+			t.Errorf("bad baseline rules target=%v %v", test.target, errRules)
+		}
+		d := comparator{rules: rules}.findOffenseMap("", im, tm)
+		if report := d.report(); report != test.report {
+			t.Errorf("report mismatch: target=%v item=%v\nexpected:\n%s\nfound:\n%s", test.target, test.item, test.report, report)
+		}
+	}
+}
+
+func TestOffenseIgnoreAbsent(t *testing.T) {
+
+	tests := []struct {
+		target string
+		item   string
+		report string
+	}{
+		{
+			target: `{"$baseline":{"ignore":["time"]},"time":"1","v":"2"}`,
+			item:   `{"time":"0","v":"2"}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"ignore":["r[*].name"]},"r":[{"id":"a","name":"x"},{"id":"b","name":"y"}]}`,
+			item:   `{"r":[{"id":"a","name":"z"},{"id":"c"}]}`,
+			report: "path=[r[1].id] value mismatch: target=b item=c\n",
+		},
+		{
+			target: `{"$baseline":{"ignore":["*.time"]},"a":{"time":"1"},"b":{"time":"2","v":"3"}}`,
+			item:   `{"a":{},"b":{"v":"3"}}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"ignore":["n[id=b]"],"keys":{"n":"id"}},"n":[{"id":"a"},{"id":"b","v":"1"}]}`,
+			item:   `{"n":[{"id":"a"},{"id":"b","v":"2"}]}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"absent":["kernelId"]},"v":"1"}`,
+			item:   `{"v":"1"}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"absent":["kernelId"]},"v":"1"}`,
+			item:   `{"v":"1","kernelId":"aki-1"}`,
+			report: "path=[kernelId] unexpected key: target=<absent> item=aki-1\n",
+		},
+		{
+			target: `{"$baseline":{"absent":["c.n[*].association"]}}`,
+			item:   `{"c":"{\"n\":[{\"id\":\"a\"},{\"id\":\"b\",\"association\":{\"ip\":\"1.2.3.4\"}}]}"}`,
+			report: `path=[c.n[1].association] unexpected key: target=<absent> item={"ip":"1.2.3.4"}` + "\n",
+		},
+		{
+			target: `{"$baseline":{"absent":["n[id=b].v"]}}`,
+			item:   `{"n":[{"id":"a","v":"1"},{"id":"b","v":"2"}]}`,
+			report: "path=[n[id=b].v] unexpected key: target=<absent> item=2\n",
+		},
+		{
+			// keys holding '.' are addressed with backslash escape
+			target: `{"$baseline":{"ignore":["tags.kubernetes\\.io/role"]},"tags":{"kubernetes.io/role":"a","Name":"web"}}`,
+			item:   `{"tags":{"kubernetes.io/role":"b","Name":"web"}}`,
+			report: "",
+		},
+		{
+			target: `{"$baseline":{"absent":["tags.aws:cloudformation\\.stack"]},"tags":{}}`,
+			item:   `{"tags":{"aws:cloudformation.stack":"s1"}}`,
+			report: `path=[tags.aws:cloudformation\.stack] unexpected key: target=<absent> item=s1` + "\n",
+		},
+	}
 
+	for _, test := range tests {
+		tm := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.target), &tm); err != nil {
+			t.Errorf("bad json target=%v %v", test.target, err)
+		}
+		im := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(test.item), &im); err != nil {
+			t.Errorf("bad json item=%v %v", test.item, err)
+		}
+		rules, tm, errRules := splitBaseline(tm)
+		if errRules != nil {
+			t.Errorf("bad baseline rules target=%v %v", test.target, errRules)
+		}
+		d := comparator{rules: rules}.compare(im, tm)
+		if report := d.report(); report != test.report {
+			t.Errorf("report mismatch: target=%v item=%v\nexpected:\n%s\nfound:\n%s", test.target, test.item, test.report, report)
+		}
+	}
 }
 
 func TestOffenseJson(t *testing.T) {
@@ -76,7 +350,7 @@ func TestOffenseJson(t *testing.T) {
 		},
 	}
 
-	dump := false
+	log := logger{} // INFO level: set level to levelDebug to trace comparison
 
 	for _, test := range tests {
 		tm := map[string]interface{}{}
@@ -87,7 +361,8 @@ func TestOffenseJson(t *testing.T) {
 		if err := json.Unmarshal([]byte(test.item), &im); err != nil {
 			t.Errorf("bad json item=%v %v", test.item, err)
 		}
-		o, annotation := findOffenseMap("", im, tm, dump)
+		d := comparator{log: log}.findOffenseMap("", im, tm)
+		o, annotation := d.found(), d.annotation()
 		if o != test.offense {
 			t.Errorf("offenseExpected=%v offenseFound=%v annotation=%s target=%v item=%v", test.offense, o, annotation, test.target, test.item)
 		}
@@ -131,11 +406,19 @@ func TestOffenseData(t *testing.T) {
 		if err := json.Unmarshal(bufTarget, &tm); err != nil {
 			t.Errorf("bad json target %s: %v", f.Name(), err)
 		}
-		dump := false
-		o, annotation := findOffenseMap("", im, tm, dump)
+		rules, tm, errRules := splitBaseline(tm)
+		if errRules != nil {
+			t.Errorf("bad baseline rules %s: %v", f.Name(), errRules)
+		}
+		log := logger{} // INFO level: set level to levelDebug to trace comparison
+		d := comparator{rules: rules, log: log}.compare(im, tm)
+		o, annotation := d.found(), d.annotation()
 		if o != expectOffense {
 			t.Errorf("%s offenseExpected=%v offenseFound=%v annotation='%s'", f.Name(), expectOffense, o, annotation)
 		}
+		if report := d.report(); report != string(bufAnnotation) {
+			t.Errorf("%s report mismatch:\nexpected:\n%s\nfound:\n%s", f.Name(), bufAnnotation, report)
+		}
 	}
 }
 
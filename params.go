@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// Rule parameter keys
+const (
+	paramBucket             = "Bucket"             // Bucket storing baselines: name, name/prefix or S3 ARN. Alias for Baseline s3://
+	paramBaseline           = "Baseline"           // Baseline store URI: s3://, file://, ssm://, dynamodb://
+	paramGroups             = "Groups"             // Optional. Comma-separated key templates of group baselines, most general first
+	paramMissingBaseline    = "MissingBaseline"    // Optional. NON_COMPLIANT (default), NOT_APPLICABLE or CAPTURE
+	paramCaptureExclude     = "CaptureExclude"     // Optional. Comma-separated path patterns removed from captured baselines
+	paramCaptureCompliance  = "CaptureCompliance"  // Optional. Result of capturing evaluation: NOT_APPLICABLE (default) or COMPLIANT
+	paramKeyTemplate        = "KeyTemplate"        // Optional. Layout of baseline keys, default {resourceType}/{resourceId}
+	paramTopicArn           = "TopicArn"           // Optional. SNS topic for non-compliance alerts
+	paramResourceTypes      = "ResourceTypes"      // Optional. Comma-separated list of accepted resource types
+	paramLogLevel           = "LogLevel"           // Optional. DEBUG, INFO (default), WARN or ERROR
+	paramDump               = "Dump"               // Optional. "ConfigItem" is an alias for LogLevel=DEBUG
+	paramForceNonCompliance = "ForceNonCompliance" // Optional. Boolean: true, false, 1, 0
+)
+
+// dumpConfigItem: only accepted value for parameter Dump, logging config items and targets
+const dumpConfigItem = "ConfigItem"
+
+// parameters: rule parameters after validation
+type parameters struct {
+	baseline           baselineURI   // store holding baselines, from Baseline or Bucket
+	bucket             string        // S3 location, if baseline store is S3
+	keyTemplate        keyTemplate   // key of baseline document in store
+	groups             []keyTemplate // keys of group documents inherited by resource document
+	missing            string        // policy for resource without baseline
+	captureExclude     [][]string    // split patterns removed from captured baselines
+	captureCompliance  configservice.ComplianceType
+	topicArn           string
+	resourceTypes      map[string]struct{} // empty: any resource type
+	forceNonCompliance bool
+	logLevel           logLevel
+}
+
+// parameterKeys: accepted keys, sorted
+func parameterKeys() []string {
+	keys := []string{paramBucket, paramBaseline, paramGroups, paramKeyTemplate, paramMissingBaseline, paramCaptureExclude, paramCaptureCompliance, paramTopicArn, paramResourceTypes, paramLogLevel, paramDump, paramForceNonCompliance}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseParameters: decode and validate RuleParameters.
+// Every problem found is reported in the error, not only the first one.
+func parseParameters(s string) (parameters, error) {
+	p := parameters{
+		keyTemplate:       defaultKeyTemplate,
+		missing:           missingNonCompliant,
+		captureExclude:    splitPatterns(defaultCaptureExclude),
+		captureCompliance: configservice.ComplianceTypeNotApplicable,
+		resourceTypes:     map[string]struct{}{},
+		logLevel:          levelInfo,
+	}
+
+	raw := map[string]interface{}{}
+	if strings.TrimSpace(s) != "" {
+		if errJson := json.Unmarshal([]byte(s), &raw); errJson != nil {
+			return p, fmt.Errorf("RuleParameters: %v", errJson)
+		}
+	}
+
+	var problems []string
+
+	for _, k := range sortedKeys(raw) {
+		value, isStr := raw[k].(string)
+		if !isStr {
+			problems = append(problems, fmt.Sprintf("%s: expected string, got %s", k, typeName(raw[k])))
+			continue
+		}
+		if errValue := p.set(k, strings.TrimSpace(value)); errValue != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", k, errValue))
+		}
+	}
+
+	_, hasBucket := raw[paramBucket]
+	_, hasBaseline := raw[paramBaseline]
+	switch {
+	case hasBucket && hasBaseline:
+		problems = append(problems, paramBucket+": conflicts with "+paramBaseline)
+	case !hasBucket && !hasBaseline:
+		problems = append(problems, paramBucket+": missing (or "+paramBaseline+")")
+	}
+
+	_, hasLogLevel := raw[paramLogLevel]
+	if dump, _ := raw[paramDump].(string); hasLogLevel && strings.TrimSpace(dump) != "" {
+		problems = append(problems, paramDump+": conflicts with "+paramLogLevel)
+	}
+
+	if len(problems) > 0 {
+		return p, fmt.Errorf("RuleParameters: %s", strings.Join(problems, "; "))
+	}
+
+	return p, nil
+}
+
+// set: validate and store one parameter
+func (p *parameters) set(key, value string) error {
+	switch key {
+	case paramBucket:
+		if value == "" {
+			return fmt.Errorf("empty")
+		}
+		if strings.HasPrefix(value, "arn:") {
+			a, errArn := arn.Parse(value)
+			if errArn != nil {
+				return errArn
+			}
+			if a.Service != "s3" {
+				return fmt.Errorf("not an S3 ARN: %s", value)
+			}
+		}
+		if name, _ := splitBucket(value); name == "" {
+			return fmt.Errorf("empty bucket name: %s", value)
+		}
+		p.bucket = value
+		p.baseline = baselineURI{scheme: schemeS3, location: value}
+	case paramBaseline:
+		if value == "" {
+			return fmt.Errorf("empty")
+		}
+		u, errURI := parseBaselineURI(value)
+		if errURI != nil {
+			return errURI
+		}
+		p.baseline = u
+		if u.scheme == schemeS3 {
+			p.bucket = u.location
+		}
+	case paramGroups:
+		for _, g := range strings.Split(value, ",") {
+			if g = strings.TrimSpace(g); g == "" {
+				continue
+			}
+			t, _, errTemplate := parseTemplate(g)
+			if errTemplate != nil {
+				return errTemplate
+			}
+			p.groups = append(p.groups, t)
+		}
+	case paramKeyTemplate:
+		t, errTemplate := parseKeyTemplate(value)
+		if errTemplate != nil {
+			return errTemplate
+		}
+		p.keyTemplate = t
+	case paramMissingBaseline:
+		switch value {
+		case missingNonCompliant, missingNotApplicable, missingCapture:
+			p.missing = value
+		default:
+			return fmt.Errorf("bad value '%s' (expected %s, %s or %s)", value, missingNonCompliant, missingNotApplicable, missingCapture)
+		}
+	case paramCaptureExclude:
+		var list []string
+		for _, e := range strings.Split(value, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		p.captureExclude = splitPatterns(list)
+	case paramCaptureCompliance:
+		switch c := configservice.ComplianceType(value); c {
+		case configservice.ComplianceTypeNotApplicable, configservice.ComplianceTypeCompliant:
+			p.captureCompliance = c
+		default:
+			return fmt.Errorf("bad value '%s' (expected %s or %s)", value, configservice.ComplianceTypeNotApplicable, configservice.ComplianceTypeCompliant)
+		}
+	case paramTopicArn:
+		if value == "" {
+			return nil
+		}
+		a, errArn := arn.Parse(value)
+		if errArn != nil {
+			return errArn
+		}
+		if a.Service != "sns" {
+			return fmt.Errorf("not an SNS ARN: %s", value)
+		}
+		p.topicArn = value
+	case paramResourceTypes:
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				p.resourceTypes[t] = struct{}{}
+			}
+		}
+		if len(p.resourceTypes) == 0 {
+			return fmt.Errorf("empty list")
+		}
+	case paramDump:
+		switch value {
+		case "":
+		case dumpConfigItem:
+			p.logLevel = levelDebug
+		default:
+			return fmt.Errorf("bad value '%s' (expected %s)", value, dumpConfigItem)
+		}
+	case paramLogLevel:
+		level, errLevel := parseLogLevel(value)
+		if errLevel != nil {
+			return errLevel
+		}
+		p.logLevel = level
+	case paramForceNonCompliance:
+		force, errBool := strconv.ParseBool(value)
+		if errBool != nil {
+			return fmt.Errorf("bad boolean '%s'", value)
+		}
+		p.forceNonCompliance = force
+	default:
+		return fmt.Errorf("unknown key (expected one of: %s)", strings.Join(parameterKeys(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseParameters(t *testing.T) {
+
+	tests := []struct {
+		params             string
+		err                string
+		bucket             string
+		topicArn           string
+		groups             int
+		resourceTypes      int
+		forceNonCompliance bool
+		debug              bool
+	}{
+		{params: `{"Bucket":"baselines"}`, bucket: "baselines"},
+		{params: `{"Bucket":" baselines/prefix "}`, bucket: "baselines/prefix"},
+		{params: `{"Bucket":"arn:aws:s3:::baselines/prefix"}`, bucket: "arn:aws:s3:::baselines/prefix"},
+		{params: `{"Bucket":"baselines","ResourceTypes":"AWS::EC2::Instance, AWS::SSM::ManagedInstanceInventory,"}`, bucket: "baselines", resourceTypes: 2},
+		{params: `{"Bucket":"baselines","TopicArn":"arn:aws:sns:sa-east-1:123456789012:drift"}`, bucket: "baselines", topicArn: "arn:aws:sns:sa-east-1:123456789012:drift"},
+		{params: `{"Bucket":"baselines","TopicArn":""}`, bucket: "baselines"},
+		{params: `{"Bucket":"baselines","Dump":"ConfigItem"}`, bucket: "baselines", debug: true},
+		{params: `{"Bucket":"baselines","LogLevel":"debug"}`, bucket: "baselines", debug: true},
+		{params: `{"Bucket":"baselines","LogLevel":"WARN"}`, bucket: "baselines"},
+		{params: `{"Bucket":"baselines","ForceNonCompliance":"true"}`, bucket: "baselines", forceNonCompliance: true},
+		{params: `{"Bucket":"baselines","ForceNonCompliance":"false"}`, bucket: "baselines"},
+		{params: `{"Baseline":"file://testdata/target"}`},
+		{params: `{"Bucket":"baselines","KeyTemplate":"{resourceId}"}`, bucket: "baselines"},
+		{params: `{"Bucket":"baselines","MissingBaseline":"NOT_APPLICABLE"}`, bucket: "baselines"},
+		{params: `{"Bucket":"baselines","MissingBaseline":"CAPTURE","CaptureCompliance":"COMPLIANT","CaptureExclude":"configurationItemCaptureTime, tags.*"}`, bucket: "baselines"},
+		{params: `{"Bucket":"baselines","CaptureCompliance":"NON_COMPLIANT"}`, err: "RuleParameters: CaptureCompliance: bad value 'NON_COMPLIANT' (expected NOT_APPLICABLE or COMPLIANT)"},
+		{params: `{"Bucket":"baselines","MissingBaseline":"IGNORE"}`, err: "RuleParameters: MissingBaseline: bad value 'IGNORE' (expected NON_COMPLIANT, NOT_APPLICABLE or CAPTURE)"},
+		{params: `{"Bucket":"baselines","Groups":"profiles/default, types/{resourceType},roles/{tag:role}"}`, bucket: "baselines", groups: 3},
+		{params: `{"Bucket":"baselines","Groups":"roles/{role}"}`, err: "RuleParameters: Groups: unknown placeholder {role} (expected {accountId}, {region}, {resourceType}, {resourceId}, {resourceName} or {tag:name}): roles/{role}"},
+		{params: `{"Bucket":"baselines","KeyTemplate":"{resourceType}"}`, err: "RuleParameters: KeyTemplate: missing {resourceId} or {resourceName}: {resourceType}"},
+		{params: `{"Baseline":"s3://baselines/prefix"}`, bucket: "baselines/prefix"},
+		{params: `{"Baseline":"ssm:///baselines"}`},
+		{params: `{"Baseline":"dynamodb://baselines?key=id"}`},
+		{params: `{"Baseline":"ftp://host/dir"}`, err: "RuleParameters: Baseline: unsupported scheme 'ftp' (expected s3, file, ssm or dynamodb): ftp://host/dir"},
+		{params: `{"Baseline":"baselines"}`, err: "RuleParameters: Baseline: missing scheme (expected s3://, file://, ssm:// or dynamodb://): baselines"},
+		{params: `{"Baseline":"dynamodb://baselines/x"}`, err: "RuleParameters: Baseline: expected dynamodb://table: dynamodb://baselines/x"},
+		{params: `{"Baseline":"dynamodb://baselines?table=x"}`, err: "RuleParameters: Baseline: unknown dynamodb option 'table' (expected key or attribute): dynamodb://baselines?table=x"},
+		{params: `{"Baseline":"s3://baselines?x=y"}`, err: "RuleParameters: Baseline: unexpected query: s3://baselines?x=y"},
+		{params: `{"Baseline":"file://testdata","Bucket":"baselines"}`, err: "RuleParameters: Bucket: conflicts with Baseline"},
+		{params: ``, err: "RuleParameters: Bucket: missing (or Baseline)"},
+		{params: `{}`, err: "RuleParameters: Bucket: missing (or Baseline)"},
+		{params: `{"Bucket":""}`, err: "RuleParameters: Bucket: empty"},
+		{params: `{"Bucket":"/prefix"}`, err: "RuleParameters: Bucket: empty bucket name: /prefix"},
+		{params: `{"Bucket":"arn:aws:sqs:sa-east-1:123456789012:queue"}`, err: "RuleParameters: Bucket: not an S3 ARN: arn:aws:sqs:sa-east-1:123456789012:queue"},
+		{params: `{"Bucket":1}`, err: "RuleParameters: Bucket: expected string, got number"},
+		{params: `{"Bucket":"baselines","TopicArn":"drift"}`, err: "RuleParameters: TopicArn: arn: invalid prefix"},
+		{params: `{"Bucket":"baselines","TopicArn":"arn:aws:sqs:sa-east-1:123456789012:queue"}`, err: "RuleParameters: TopicArn: not an SNS ARN: arn:aws:sqs:sa-east-1:123456789012:queue"},
+		{params: `{"Bucket":"baselines","ResourceTypes":" , "}`, err: "RuleParameters: ResourceTypes: empty list"},
+		{params: `{"Bucket":"baselines","Dump":"yes"}`, err: "RuleParameters: Dump: bad value 'yes' (expected ConfigItem)"},
+		{params: `{"Bucket":"baselines","LogLevel":"TRACE"}`, err: "RuleParameters: LogLevel: bad value 'TRACE' (expected DEBUG, INFO, WARN, ERROR)"},
+		{params: `{"Bucket":"baselines","LogLevel":"INFO","Dump":"ConfigItem"}`, err: "RuleParameters: Dump: conflicts with LogLevel"},
+		{params: `{"Bucket":"baselines","ForceNonCompliance":"yes"}`, err: "RuleParameters: ForceNonCompliance: bad boolean 'yes'"},
+		{params: `{"Buckett":"baselines","Dump":"x"}`, err: "RuleParameters: Buckett: unknown key (expected one of: Baseline, Bucket, CaptureCompliance, CaptureExclude, Dump, ForceNonCompliance, Groups, KeyTemplate, LogLevel, MissingBaseline, ResourceTypes, TopicArn); Dump: bad value 'x' (expected ConfigItem); Bucket: missing (or Baseline)"},
+		{params: `["baselines"]`, err: "RuleParameters: json: cannot unmarshal array into Go value of type map[string]interface {}"},
+	}
+
+	for _, test := range tests {
+		p, err := parseParameters(test.params)
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("params=%s\nexpected error: %s\ngot error: %s", test.params, test.err, errStr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if p.bucket != test.bucket {
+			t.Errorf("params=%s bucket: expected=%s got=%s", test.params, test.bucket, p.bucket)
+		}
+		if p.topicArn != test.topicArn {
+			t.Errorf("params=%s topicArn: expected=%s got=%s", test.params, test.topicArn, p.topicArn)
+		}
+		if len(p.groups) != test.groups {
+			t.Errorf("params=%s groups: expected=%d got=%d", test.params, test.groups, len(p.groups))
+		}
+		if len(p.resourceTypes) != test.resourceTypes {
+			t.Errorf("params=%s resourceTypes: expected=%d got=%d", test.params, test.resourceTypes, len(p.resourceTypes))
+		}
+		if p.forceNonCompliance != test.forceNonCompliance {
+			t.Errorf("params=%s forceNonCompliance: expected=%v got=%v", test.params, test.forceNonCompliance, p.forceNonCompliance)
+		}
+		if debug := p.logLevel == levelDebug; debug != test.debug {
+			t.Errorf("params=%s debug: expected=%v got=%v", test.params, test.debug, p.logLevel)
+		}
+	}
+}
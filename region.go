@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// arnRegion: region field of ARN, empty if s is not an ARN or the ARN has no region.
+//
+// arn:aws:sns:us-east-1:123456789012:topic => us-east-1
+func arnRegion(s string) string {
+	if !strings.HasPrefix(s, "arn:") {
+		return ""
+	}
+	a, errArn := arn.Parse(s)
+	if errArn != nil {
+		return ""
+	}
+	return a.Region
+}
+
+// splitBucket: bucket name and key prefix from parameter Bucket.
+// Bucket may be a plain name, name/prefix or an S3 ARN. The region field,
+// if present in the ARN, selects the region of the S3 client.
+//
+// my-bucket                                => my-bucket
+// my-bucket/baselines/                     => my-bucket baselines
+// arn:aws:s3:::my-bucket/baselines         => my-bucket baselines
+// arn:aws:s3:us-east-1::my-bucket/baselines => my-bucket baselines
+func splitBucket(bucket string) (name, prefix string) {
+	if strings.HasPrefix(bucket, "arn:") {
+		if a, errArn := arn.Parse(bucket); errArn == nil {
+			bucket = a.Resource
+		}
+	}
+	list := strings.SplitN(bucket, "/", 2)
+	name = list[0]
+	if len(list) > 1 {
+		prefix = strings.Trim(list[1], "/")
+	}
+	return
+}
+
+// clientRegion: region for a client, from ARN if available, otherwise default region
+func clientRegion(defaultRegion, resourceArn string) string {
+	if region := arnRegion(resourceArn); region != "" {
+		return region
+	}
+	return defaultRegion
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSplitBucket(t *testing.T) {
+
+	tests := []struct {
+		bucket string
+		name   string
+		prefix string
+	}{
+		{"my-bucket", "my-bucket", ""},
+		{"my-bucket/", "my-bucket", ""},
+		{"my-bucket/baselines", "my-bucket", "baselines"},
+		{"my-bucket/baselines/", "my-bucket", "baselines"},
+		{"my-bucket/a/b", "my-bucket", "a/b"},
+		{"arn:aws:s3:::my-bucket", "my-bucket", ""},
+		{"arn:aws:s3:us-east-1::my-bucket/baselines", "my-bucket", "baselines"},
+	}
+
+	for _, test := range tests {
+		name, prefix := splitBucket(test.bucket)
+		if name != test.name || prefix != test.prefix {
+			t.Errorf("bucket=%s expected=%s,%s got=%s,%s", test.bucket, test.name, test.prefix, name, prefix)
+		}
+	}
+}
+
+func TestClientRegion(t *testing.T) {
+
+	tests := []struct {
+		arn    string
+		region string
+	}{
+		{"", "sa-east-1"},
+		{"my-bucket/prefix", "sa-east-1"},
+		{"arn:aws:s3:::my-bucket", "sa-east-1"},
+		{"arn:aws:s3:us-east-1::my-bucket", "us-east-1"},
+		{"arn:aws:sns:eu-west-1:123456789012:topic", "eu-west-1"},
+	}
+
+	for _, test := range tests {
+		if region := clientRegion("sa-east-1", test.arn); region != test.region {
+			t.Errorf("arn=%s expected=%s got=%s", test.arn, test.region, region)
+		}
+	}
+}
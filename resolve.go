@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// baselineSource: one document taking part in the baseline of a resource
+type baselineSource struct {
+	key   string
+	group bool // group document, shared by many resources
+}
+
+// baselineSources: keys of group documents, most general first, then the resource document.
+// Group templates using placeholders absent from item, like a missing tag, are skipped.
+func (r rule) baselineSources(ctx context.Context, item configurationItem) ([]baselineSource, error) {
+	var sources []baselineSource
+	for _, g := range r.groups {
+		key, errKey := g.render(item)
+		if errKey != nil {
+			loggerFrom(ctx).debug("group skipped", logStage, "baseline", logError, errKey)
+			continue
+		}
+		sources = append(sources, baselineSource{key: key, group: true})
+	}
+	key, errKey := r.keyTemplate.render(item)
+	if errKey != nil {
+		return nil, errKey
+	}
+	return append(sources, baselineSource{key: key}), nil
+}
+
+// extendsKey: directive under $baseline naming parent documents, by store key.
+// Parents are merged in listed order, then the document itself overrides them:
+//
+//	"$baseline": {"extends": ["hardening/ec2", "teams/web/ec2"]}
+const extendsKey = "extends"
+
+// maxExtendsDepth: longest chain of documents extending each other
+const maxExtendsDepth = 10
+
+// resolvedBaseline: effective target and the documents it came from
+type resolvedBaseline struct {
+	target map[string]interface{}
+	used   []string          // locations of documents merged, in merge order
+	origin map[string]string // target path => key of document defining it
+	absent map[string]string // absent pattern => key of document defining it
+}
+
+// source: key of document defining target path, from longest recorded prefix
+func (b resolvedBaseline) source(path string) string {
+	var best string
+	var found bool
+	for p := range b.origin {
+		if p == path || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			if !found || len(p) > len(best) {
+				best, found = p, true
+			}
+		}
+	}
+	if found {
+		return b.origin[best]
+	}
+	// offense from absent directive
+	patterns := make([]string, 0, len(b.absent))
+	for p := range b.absent {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	segments := splitPath(path)
+	for _, pattern := range patterns {
+		if matchPath(splitPath(pattern), segments) {
+			return b.absent[pattern]
+		}
+	}
+	return ""
+}
+
+// attribute: record source document in offenses, if target was composed from many documents
+func (b resolvedBaseline) attribute(d drift) {
+	if len(b.used) < 2 {
+		return
+	}
+	for i := range d {
+		d[i].Source = b.source(d[i].Path)
+	}
+}
+
+// resolveBaseline: merge documents found for sources, more specific documents overriding inherited fields.
+// Documents may extend parent documents, which are merged before them.
+// Missing source documents are skipped, but at least one document must exist.
+// Missing parent documents are errors.
+func resolveBaseline(ctx context.Context, store BaselineStore, sources []baselineSource) (resolvedBaseline, error) {
+
+	r := resolver{ctx: ctx, store: store, res: resolvedBaseline{origin: map[string]string{}, absent: map[string]string{}}}
+
+	for _, s := range sources {
+		if errApply := r.apply(s.key, nil); errApply != nil {
+			if isNotFound(errApply) {
+				continue
+			}
+			return r.res, errApply
+		}
+	}
+
+	if r.res.target == nil {
+		// report resource document as missing
+		return r.res, notFoundError{store.Location(sources[len(sources)-1].key)}
+	}
+
+	return r.res, nil
+}
+
+// resolver: accumulates documents into effective target
+type resolver struct {
+	ctx   context.Context
+	store BaselineStore
+	res   resolvedBaseline
+}
+
+// apply: merge parents of document key, then the document itself.
+// chain holds the documents extending key, to detect cycles.
+func (r *resolver) apply(key string, chain []string) error {
+
+	for _, k := range chain {
+		if k == key {
+			return fmt.Errorf("%s: cycle: %s -> %s", extendsKey, strings.Join(chain, " -> "), key)
+		}
+	}
+	if len(chain) >= maxExtendsDepth {
+		return fmt.Errorf("%s: chain longer than %d: %s -> %s", extendsKey, maxExtendsDepth, strings.Join(chain, " -> "), key)
+	}
+
+	doc, errFetch := fetch(r.ctx, r.store, key)
+	if errFetch != nil {
+		if len(chain) > 0 && isNotFound(errFetch) {
+			return fmt.Errorf("%s: parent of %s: %v", extendsKey, chain[len(chain)-1], errFetch)
+		}
+		return errFetch
+	}
+
+	parents, doc, errExtends := splitExtends(doc)
+	if errExtends != nil {
+		return fmt.Errorf("%s: %v", r.store.Location(key), errExtends)
+	}
+
+	for _, p := range parents {
+		if errParent := r.apply(p, append(chain, key)); errParent != nil {
+			return errParent
+		}
+	}
+
+	r.res.used = append(r.res.used, r.store.Location(key))
+	for k, v := range doc {
+		if k != baselineKey {
+			recordOrigin(r.res.origin, k, v, key)
+		}
+	}
+	if directives, isMap := doc[baselineKey].(map[string]interface{}); isMap {
+		if list, isSlice := directives["absent"].([]interface{}); isSlice {
+			for _, p := range list {
+				if s, isStr := p.(string); isStr {
+					r.res.absent[s] = key
+				}
+			}
+		}
+	}
+	if r.res.target == nil {
+		r.res.target = doc
+		return nil
+	}
+	r.res.target = mergeBaseline(r.res.target, doc)
+
+	return nil
+}
+
+// splitExtends: parent keys from directive extends, and the document without it
+func splitExtends(doc map[string]interface{}) ([]string, map[string]interface{}, error) {
+	directives, isMap := doc[baselineKey].(map[string]interface{})
+	if !isMap {
+		return nil, doc, nil
+	}
+	value, found := directives[extendsKey]
+	if !found {
+		return nil, doc, nil
+	}
+
+	var parents []string
+	switch v := value.(type) {
+	case string:
+		parents = []string{v}
+	case []interface{}:
+		for _, p := range v {
+			s, isStr := p.(string)
+			if !isStr {
+				return nil, doc, fmt.Errorf("%s: %s: non-string parent: %v", baselineKey, extendsKey, p)
+			}
+			parents = append(parents, s)
+		}
+	default:
+		return nil, doc, fmt.Errorf("%s: %s: expected string or list of strings: %v", baselineKey, extendsKey, value)
+	}
+	for _, p := range parents {
+		if p == "" {
+			return nil, doc, fmt.Errorf("%s: %s: empty parent", baselineKey, extendsKey)
+		}
+	}
+
+	clean := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		clean[k] = v
+	}
+	rules := make(map[string]interface{}, len(directives))
+	for k, v := range directives {
+		if k != extendsKey {
+			rules[k] = v
+		}
+	}
+	if len(rules) == 0 {
+		delete(clean, baselineKey)
+	} else {
+		clean[baselineKey] = rules
+	}
+
+	return parents, clean, nil
+}
+
+// recordOrigin: document key as origin of every leaf under path in value.
+// Entries recorded for path by previous documents are replaced.
+func recordOrigin(origin map[string]string, path string, value interface{}, key string) {
+	if m, isMap := mergeableMap(value); isMap && len(m) > 0 && !isMatcher(m) {
+		delete(origin, path) // previous leaf replaced by map
+		for k, v := range m {
+			recordOrigin(origin, pathKey(path, k), v, key)
+		}
+		return
+	}
+	for p := range origin {
+		if strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(origin, p) // previous map replaced by leaf
+		}
+	}
+	origin[path] = key
+}
+
+// mergeBaseline: deep merge override into base, override wins.
+// Maps, including maps encoded as JSON strings like configuration, are merged
+// key by key; slices, scalars and matchers are replaced.
+// Lists ignore and absent under $baseline are concatenated, so inherited
+// exclusions still apply.
+func mergeBaseline(base, override map[string]interface{}) map[string]interface{} {
+	merged := mergeMap(base, override)
+
+	baseRules, baseFound := base[baselineKey].(map[string]interface{})
+	overRules, overFound := override[baselineKey].(map[string]interface{})
+	if !baseFound || !overFound {
+		return merged
+	}
+	rules := merged[baselineKey].(map[string]interface{})
+	for _, list := range []string{"ignore", "absent"} {
+		b, isSliceBase := baseRules[list].([]interface{})
+		o, isSliceOver := overRules[list].([]interface{})
+		if isSliceBase && isSliceOver {
+			rules[list] = append(append([]interface{}{}, b...), o...)
+		}
+	}
+
+	return merged
+}
+
+func mergeMap(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, ov := range override {
+		om, isMapOver := mergeableMap(ov)
+		bm, isMapBase := mergeableMap(merged[k])
+		if isMapOver && isMapBase && !isMatcher(om) && !isMatcher(bm) {
+			merged[k] = mergeMap(bm, om)
+			continue
+		}
+		merged[k] = ov
+	}
+	return merged
+}
+
+// mergeableMap: value as map, decoding string-encoded JSON
+func mergeableMap(v interface{}) (map[string]interface{}, bool) {
+	if m, isMap := v.(map[string]interface{}); isMap {
+		return m, true
+	}
+	return decodeStrJsonMap(v)
+}
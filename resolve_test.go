@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeBaseline(t *testing.T) {
+
+	tests := []struct {
+		base     string
+		override string
+		expected string
+	}{
+		{
+			base:     `{"a":1,"b":{"c":2,"d":3}}`,
+			override: `{"b":{"d":4},"e":5}`,
+			expected: `{"a":1,"b":{"c":2,"d":4},"e":5}`,
+		},
+		{
+			// slices are replaced
+			base:     `{"a":[1,2]}`,
+			override: `{"a":[3]}`,
+			expected: `{"a":[3]}`,
+		},
+		{
+			// matchers are replaced
+			base:     `{"a":{"$min":1,"$max":10}}`,
+			override: `{"a":{"$max":5}}`,
+			expected: `{"a":{"$max":5}}`,
+		},
+		{
+			// string-encoded json is merged
+			base:     `{"configuration":"{\"instanceType\":\"t2.micro\",\"ebsOptimized\":false}"}`,
+			override: `{"configuration":{"instanceType":"t2.large"}}`,
+			expected: `{"configuration":{"ebsOptimized":false,"instanceType":"t2.large"}}`,
+		},
+		{
+			// exclusion lists are concatenated
+			base:     `{"$baseline":{"ignore":["a"],"slices":{"b":"set"}}}`,
+			override: `{"$baseline":{"ignore":["c"],"absent":["d"]}}`,
+			expected: `{"$baseline":{"absent":["d"],"ignore":["a","c"],"slices":{"b":"set"}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		var base, override, expected map[string]interface{}
+		if errJson := json.Unmarshal([]byte(test.base), &base); errJson != nil {
+			t.Errorf("base: %v", errJson)
+			continue
+		}
+		if errJson := json.Unmarshal([]byte(test.override), &override); errJson != nil {
+			t.Errorf("override: %v", errJson)
+			continue
+		}
+		if errJson := json.Unmarshal([]byte(test.expected), &expected); errJson != nil {
+			t.Errorf("expected: %v", errJson)
+			continue
+		}
+		merged := mergeBaseline(base, override)
+		if !reflect.DeepEqual(merged, expected) {
+			buf, _ := json.Marshal(merged)
+			t.Errorf("base=%s override=%s\nexpected: %s\ngot:      %s", test.base, test.override, test.expected, string(buf))
+		}
+	}
+}
+
+func TestResolveBaseline(t *testing.T) {
+
+	store := &FakeStore{Documents: map[string]string{
+		"profiles/default":          `{"configurationItemStatus":"OK","configuration":{"ebsOptimized":false}}`,
+		"roles/web":                 `{"configuration":{"instanceType":"t2.micro","monitoring":"disabled"}}`,
+		"AWS::EC2::Instance/i-1":    `{"configuration":{"instanceType":"t2.large"}}`,
+		"AWS::EC2::Instance/broken": `{`,
+	}}
+
+	r := rule{}
+	r.keyTemplate = defaultKeyTemplate
+	r.groups = []keyTemplate{"profiles/default", "roles/{tag:role}"}
+
+	tests := []struct {
+		item     configurationItem
+		expected string
+		used     string
+		err      string
+	}{
+		{
+			item:     configurationItem{ResourceType: "AWS::EC2::Instance", ResourceId: "i-1", Tags: map[string]string{"role": "web"}},
+			expected: `{"configurationItemStatus":"OK","configuration":{"ebsOptimized":false,"instanceType":"t2.large","monitoring":"disabled"}}`,
+			used:     "fake://profiles/default fake://roles/web fake://AWS::EC2::Instance/i-1",
+		},
+		{
+			// no role tag: group skipped, no resource document: group only
+			item:     configurationItem{ResourceType: "AWS::EC2::Instance", ResourceId: "i-2"},
+			expected: `{"configurationItemStatus":"OK","configuration":{"ebsOptimized":false}}`,
+			used:     "fake://profiles/default",
+		},
+		{
+			item: configurationItem{ResourceType: "AWS::EC2::Instance", ResourceId: "broken"},
+			err:  "fake://AWS::EC2::Instance/broken: unexpected end of JSON input",
+		},
+	}
+
+	for _, test := range tests {
+		sources, errSources := r.baselineSources(context.Background(), test.item)
+		if errSources != nil {
+			t.Errorf("resource=%s: %v", test.item.ResourceId, errSources)
+			continue
+		}
+		resolved, errResolve := resolveBaseline(context.Background(), store, sources)
+		var errStr string
+		if errResolve != nil {
+			errStr = errResolve.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("resource=%s\nexpected error: %s\ngot error: %s", test.item.ResourceId, test.err, errStr)
+			continue
+		}
+		if errResolve != nil {
+			continue
+		}
+		if u := strings.Join(resolved.used, " "); u != test.used {
+			t.Errorf("resource=%s used: expected=%s got=%s", test.item.ResourceId, test.used, u)
+		}
+		var expected map[string]interface{}
+		if errJson := json.Unmarshal([]byte(test.expected), &expected); errJson != nil {
+			t.Errorf("expected: %v", errJson)
+			continue
+		}
+		if !reflect.DeepEqual(resolved.target, expected) {
+			buf, _ := json.Marshal(resolved.target)
+			t.Errorf("resource=%s\nexpected: %s\ngot:      %s", test.item.ResourceId, test.expected, string(buf))
+		}
+	}
+
+	// no document at all: resource document reported missing
+	r.groups = nil
+	sources, _ := r.baselineSources(context.Background(), configurationItem{ResourceType: "AWS::EC2::Instance", ResourceId: "i-9"})
+	if _, err := resolveBaseline(context.Background(), store, sources); !isNotFound(err) || err.Error() != "baseline not found: fake://AWS::EC2::Instance/i-9" {
+		t.Errorf("expected not found for resource document, got: %v", err)
+	}
+}
+
+func TestResolveExtends(t *testing.T) {
+
+	store := &FakeStore{Documents: map[string]string{
+		"hardening/ec2": `{"configuration":{"ebsOptimized":true,"monitoring":{"state":"enabled"}},"$baseline":{"ignore":["configurationItemCaptureTime"]}}`,
+		"teams/web":     `{"$baseline":{"extends":"hardening/ec2"},"configuration":{"instanceType":"t2.micro"}}`,
+		"i-1":           `{"$baseline":{"extends":["teams/web"],"absent":["configuration.publicIpAddress"]},"configuration":{"monitoring":{"state":"disabled"}}}`,
+		"loop-a":        `{"$baseline":{"extends":"loop-b"}}`,
+		"loop-b":        `{"$baseline":{"extends":"loop-a"}}`,
+		"orphan":        `{"$baseline":{"extends":"missing"}}`,
+		"bad":           `{"$baseline":{"extends":1}}`,
+	}}
+
+	tests := []struct {
+		key      string
+		expected string
+		used     string
+		err      string
+	}{
+		{
+			key:      "i-1",
+			expected: `{"configuration":{"ebsOptimized":true,"instanceType":"t2.micro","monitoring":{"state":"disabled"}},"$baseline":{"ignore":["configurationItemCaptureTime"],"absent":["configuration.publicIpAddress"]}}`,
+			used:     "fake://hardening/ec2 fake://teams/web fake://i-1",
+		},
+		{key: "loop-a", err: "extends: cycle: loop-a -> loop-b -> loop-a"},
+		{key: "orphan", err: "extends: parent of orphan: baseline not found: fake://missing"},
+		{key: "bad", err: "fake://bad: $baseline: extends: expected string or list of strings: 1"},
+	}
+
+	for _, test := range tests {
+		resolved, errResolve := resolveBaseline(context.Background(), store, []baselineSource{{key: test.key}})
+		var errStr string
+		if errResolve != nil {
+			errStr = errResolve.Error()
+		}
+		if errStr != test.err {
+			t.Errorf("key=%s\nexpected error: %s\ngot error: %s", test.key, test.err, errStr)
+			continue
+		}
+		if errResolve != nil {
+			continue
+		}
+		if u := strings.Join(resolved.used, " "); u != test.used {
+			t.Errorf("key=%s used: expected=%s got=%s", test.key, test.used, u)
+		}
+		var expected map[string]interface{}
+		if errJson := json.Unmarshal([]byte(test.expected), &expected); errJson != nil {
+			t.Errorf("expected: %v", errJson)
+			continue
+		}
+		if !reflect.DeepEqual(resolved.target, expected) {
+			buf, _ := json.Marshal(resolved.target)
+			t.Errorf("key=%s\nexpected: %s\ngot:      %s", test.key, test.expected, string(buf))
+		}
+	}
+
+	// offenses name the document defining the violated expectation
+	resolved, errResolve := resolveBaseline(context.Background(), store, []baselineSource{{key: "i-1"}})
+	if errResolve != nil {
+		t.Fatalf("resolve: %v", errResolve)
+	}
+	rules, target, errRules := splitBaseline(resolved.target)
+	if errRules != nil {
+		t.Fatalf("baseline: %v", errRules)
+	}
+	item := map[string]interface{}{
+		"configurationItemCaptureTime": "2019-06-10T14:21:07.000Z",
+		"configuration": map[string]interface{}{
+			"ebsOptimized":    false,
+			"instanceType":    "t2.large",
+			"monitoring":      map[string]interface{}{"state": "enabled"},
+			"publicIpAddress": "1.2.3.4",
+		},
+	}
+	d := comparator{rules: rules}.compare(item, target)
+	resolved.attribute(d)
+	expectedReport := `path=[configuration.ebsOptimized] value mismatch: target=true item=false source=hardening/ec2
+path=[configuration.instanceType] value mismatch: target=t2.micro item=t2.large source=teams/web
+path=[configuration.monitoring.state] value mismatch: target=disabled item=enabled source=i-1
+path=[configuration.publicIpAddress] unexpected key: target=<absent> item=1.2.3.4 source=i-1
+`
+	if report := d.report(); report != expectedReport {
+		t.Errorf("report:\nexpected:\n%s\ngot:\n%s", expectedReport, report)
+	}
+}
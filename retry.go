@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// retryPolicy: attempts and backoff for one AWS operation
+type retryPolicy struct {
+	attempts int           // total attempts, including the first one
+	base     time.Duration // wait before the second attempt, doubled for each further one
+	max      time.Duration // longest single wait
+}
+
+// Operations retried apart from AWS API calls
+const (
+	opStore = "store" // baseline store Get and Put
+)
+
+// retryPolicies: per-operation caps. Calls on the evaluation path get few
+// attempts, so a throttled resource does not consume the whole deadline.
+var retryPolicies = map[string]retryPolicy{
+	"GetResourceConfigHistory": {attempts: 4, base: 100 * time.Millisecond, max: 2 * time.Second},
+	"ListDiscoveredResources":  {attempts: 5, base: 200 * time.Millisecond, max: 5 * time.Second},
+	"SelectResourceConfig":     {attempts: 5, base: 200 * time.Millisecond, max: 5 * time.Second},
+	"PutEvaluations":           {attempts: 3, base: 200 * time.Millisecond, max: 5 * time.Second},
+	"Publish":                  {attempts: 3, base: 100 * time.Millisecond, max: time.Second},
+}
+
+// defaultRetryPolicy: operations missing from retryPolicies
+var defaultRetryPolicy = retryPolicy{attempts: 3, base: 200 * time.Millisecond, max: 2 * time.Second}
+
+func policyFor(operation string) retryPolicy {
+	if p, found := retryPolicies[operation]; found {
+		return p
+	}
+	return defaultRetryPolicy
+}
+
+// retryableCodes: AWS error codes for throttling and server-side failures.
+// The only table of retryable codes: the baseline store adds its own on top (see storeTransientCodes).
+var retryableCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestLimitExceeded":                   true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"SlowDown":                               true,
+	"RequestTimeout":                         true,
+	"RequestTimeoutException":                true,
+	"ServiceUnavailable":                     true,
+	"ServiceUnavailableException":            true,
+	"InternalError":                          true,
+	"InternalFailure":                        true,
+	"InternalServerError":                    true,
+}
+
+// isRetryable: err may go away on retry: throttling, server-side failure,
+// or store failure marked transient by storeError
+func isRetryable(err error) bool {
+	if isTransient(err) {
+		return true
+	}
+	if reqErr, isReq := err.(awserr.RequestFailure); isReq && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	awsErr, isAws := err.(awserr.Error)
+	return isAws && retryableCodes[awsErr.Code()]
+}
+
+var (
+	jitterMutex sync.Mutex
+	jitter      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff: wait after failed attempt, exponential with jitter.
+// The wait is drawn from the upper half of the exponential step, so
+// concurrent invocations spread out while still backing off.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	step := p.base
+	for i := 1; i < attempt && (p.max <= 0 || step < p.max); i++ {
+		step *= 2
+	}
+	if p.max > 0 && step > p.max {
+		step = p.max
+	}
+	if step <= 1 {
+		return step
+	}
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+	return step/2 + time.Duration(jitter.Int63n(int64(step/2)+1))
+}
+
+// retry: call f until it succeeds, fails with non-retryable error, or the
+// attempts of operation run out. Never waits past the deadline of ctx:
+// the last error is returned instead.
+func retry(ctx context.Context, operation string, f func() error) error {
+	return retryWith(ctx, operation, policyFor(operation), f)
+}
+
+func retryWith(ctx context.Context, operation string, p retryPolicy, f func() error) error {
+	log := loggerFrom(ctx).with("operation", operation)
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !isRetryable(err) || attempt >= p.attempts {
+			return err
+		}
+		wait := p.backoff(attempt)
+		if !waitFits(ctx, wait) {
+			log.warn("retry abandoned: deadline", logError, err, "attempt", attempt, "attempts", p.attempts, "backoff", wait)
+			return err
+		}
+		log.warn("retrying", logError, err, "attempt", attempt, "attempts", p.attempts, "backoff", wait)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// waitFits: waiting d still leaves ctx alive
+func waitFits(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	return !hasDeadline || time.Until(deadline) > d
+}
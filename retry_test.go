@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 10, base: 100 * time.Millisecond, max: time.Second}
+
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second}, // capped
+		{60, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, data := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(data.attempt); d < data.min || d > data.max {
+				t.Errorf("attempt=%d: backoff %v out of [%v,%v]", data.attempt, d, data.min, data.max)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{fmt.Errorf("plain"), false},
+		{awserr.New("ThrottlingException", "slow down", nil), true},
+		{awserr.New("ServiceUnavailable", "unavailable", nil), true},
+		{awserr.New("AccessDeniedException", "denied", nil), false},
+		{awserr.New("InvalidParameter", "invalid", nil), false},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "boom", nil), 502, "req"), true},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "bad", nil), 400, "req"), false},
+		{transientError{fmt.Errorf("store")}, true},
+	}
+
+	for _, data := range tests {
+		if got := isRetryable(data.err); got != data.retryable {
+			t.Errorf("%v: expected retryable=%v got=%v", data.err, data.retryable, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := retryPolicy{attempts: 3, base: time.Millisecond, max: time.Millisecond}
+	throttled := awserr.New("ThrottlingException", "slow down", nil)
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		calls    int
+		fails    bool
+	}{
+		{"success", 0, throttled, 1, false},
+		{"recovered", 2, throttled, 3, false},
+		{"exhausted", 5, throttled, 3, true},
+		{"not retryable", 5, awserr.New("AccessDeniedException", "denied", nil), 1, true},
+	}
+
+	for _, data := range tests {
+		var calls int
+		err := retryWith(context.Background(), "test", p, func() error {
+			calls++
+			if calls <= data.failures {
+				return data.err
+			}
+			return nil
+		})
+		if calls != data.calls || (err != nil) != data.fails {
+			t.Errorf("%s: calls=%d err=%v", data.name, calls, err)
+		}
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	// backoff longer than the time left: give up instead of waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	p := retryPolicy{attempts: 5, base: time.Hour, max: time.Hour}
+	var calls int
+	begin := time.Now()
+	err := retryWith(ctx, "test", p, func() error {
+		calls++
+		return awserr.New("ThrottlingException", "slow down", nil)
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("calls=%d err=%v", calls, err)
+	}
+	if elapsed := time.Since(begin); elapsed > 40*time.Millisecond {
+		t.Errorf("waited %v for hopeless retry", elapsed)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// handleScheduled: periodic trigger carries no configuration item,
+// so evaluate every resource of types listed in parameter ResourceTypes.
+// Resources not evaluated before work ctx expires are skipped and reported;
+// evaluations already done are still submitted using ctx.
+func handleScheduled(ctx, work context.Context, clientConf *Clients, r rule, notification scheduledNotification) (out Out, err error) {
+
+	out = Out{"ok"}
+
+	log := loggerFrom(ctx).with(logStage, "scheduled")
+
+	if len(r.resourceTypes) == 0 {
+		err = fmt.Errorf("%s: missing rule parameter ResourceTypes", messageScheduled)
+		out.Str = err.Error()
+		log.error("invocation failed", logOutcome, "failed", logError, err)
+		return
+	}
+
+	// Periodic evaluations are ordered by notification time
+	t := notification.NotificationCreationTime
+
+	types := make([]string, 0, len(r.resourceTypes))
+	for rt := range r.resourceTypes {
+		types = append(types, rt)
+	}
+	sort.Strings(types)
+
+	var resources, failures, skipped int
+	tally := map[configservice.ComplianceType]int{}
+
+	sub := newSubmitter(clientConf.Config, r.resultToken)
+
+	for _, resourceType := range types {
+		if work.Err() != nil {
+			log.warn("deadline: skipping resource type", logResourceType, resourceType)
+			continue
+		}
+
+		ids, errList := listResources(work, clientConf.Config, resourceType)
+		if errList != nil {
+			log.error("ListDiscoveredResources", logOutcome, "failed", logResourceType, resourceType, logError, errList)
+			failures++
+			continue
+		}
+
+		log.info("ListDiscoveredResources", logOutcome, "ok", logResourceType, resourceType, "resources", len(ids))
+
+		for _, resourceId := range ids {
+			resources++
+
+			if work.Err() != nil {
+				skipped++
+				continue
+			}
+
+			itemHistory, errHistory := getHistory(work, clientConf.Config, resourceType, resourceId)
+			if errHistory != nil {
+				log.error("getHistory", logOutcome, "failed", logResourceType, resourceType, logResourceId, resourceId, logError, errHistory)
+				failures++
+				continue
+			}
+
+			item, errItem := itemFromHistory(itemHistory)
+			if errItem != nil {
+				log.error("history item", logOutcome, "failed", logResourceType, resourceType, logResourceId, resourceId, logError, errItem)
+				failures++
+				continue
+			}
+
+			res, errEval := evalItem(work, clientConf, r, item, t, sub)
+			if errEval != nil {
+				log.error("evaluation", logOutcome, "failed", logResourceType, resourceType, logResourceId, resourceId, logError, errEval)
+				if work.Err() != nil {
+					skipped++
+				} else {
+					failures++
+				}
+				continue
+			}
+			tally[res.compliance]++
+		}
+	}
+
+	errSubmit := sub.flush(ctx)
+
+	out.Str = fmt.Sprintf("%s: resources=%d compliant=%d non_compliant=%d not_applicable=%d failures=%d skipped=%d",
+		messageScheduled, resources,
+		tally[configservice.ComplianceTypeCompliant],
+		tally[configservice.ComplianceTypeNonCompliant],
+		tally[configservice.ComplianceTypeNotApplicable],
+		failures, skipped)
+	if errWork := work.Err(); errWork != nil {
+		out.Str += fmt.Sprintf(" deadline: %v", errWork)
+	}
+	if errSubmit != nil {
+		out.Str += " " + errSubmit.Error()
+	}
+	summary := []interface{}{"resources", resources,
+		"compliant", tally[configservice.ComplianceTypeCompliant],
+		"nonCompliant", tally[configservice.ComplianceTypeNonCompliant],
+		"notApplicable", tally[configservice.ComplianceTypeNotApplicable],
+		"failures", failures, "skipped", skipped}
+
+	if failures > 0 || skipped > 0 || errSubmit != nil {
+		err = fmt.Errorf("%s", out.Str)
+		log.error("invocation failed", append(summary, logOutcome, "failed", logError, err)...)
+		return
+	}
+
+	log.info(messageScheduled, append(summary, logOutcome, "ok")...)
+
+	return
+}
+
+// listResources: ids of all discovered resources of given type
+func listResources(ctx context.Context, configClient ConfigAPI, resourceType string) ([]string, error) {
+
+	var ids []string
+
+	params := configservice.ListDiscoveredResourcesInput{
+		Limit:        aws.Int64(100),
+		ResourceType: configservice.ResourceType(resourceType),
+	}
+
+	for {
+		var resp *configservice.ListDiscoveredResourcesOutput
+		errList := retry(ctx, "ListDiscoveredResources", func() error {
+			var errCall error
+			resp, errCall = configClient.ListDiscoveredResources(ctx, &params)
+			return errCall
+		})
+		if errList != nil {
+			return ids, errList
+		}
+
+		for _, ri := range resp.ResourceIdentifiers {
+			if ri.ResourceId != nil {
+				ids = append(ids, *ri.ResourceId)
+			}
+		}
+
+		if resp.NextToken == nil || *resp.NextToken == "" {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+
+	return ids, nil
+}
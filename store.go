@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Baseline store URI schemes
+const (
+	schemeS3       = "s3"       // s3://bucket/prefix
+	schemeFile     = "file"     // file:///dir or file://relative/dir
+	schemeSSM      = "ssm"      // ssm:///path/prefix
+	schemeDynamoDB = "dynamodb" // dynamodb://table?key=key&attribute=baseline
+)
+
+// Default attribute names for DynamoDB store
+const (
+	dynamoKeyAttribute  = "key"      // partition key, string holding baseline key
+	dynamoDocAttribute  = "baseline" // baseline document, either JSON string or map
+	dynamoQueryKey      = "key"      // URI query parameter overriding dynamoKeyAttribute
+	dynamoQueryDocument = "attribute"
+)
+
+// BaselineStore: source of baseline documents
+type BaselineStore interface {
+	// Get: raw baseline document for key. Missing document is reported as notFoundError.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put: store baseline document under key.
+	// Without overwrite, an existing document is kept and reported as existsError.
+	Put(ctx context.Context, key string, doc []byte, overwrite bool) error
+	// Location: human readable location of key, like s3://bucket/prefix/key
+	Location(key string) string
+}
+
+// notFoundError: baseline document does not exist in store
+type notFoundError struct {
+	location string
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("baseline not found: %s", e.location)
+}
+
+// isNotFound: err reports missing baseline document
+func isNotFound(err error) bool {
+	_, notFound := err.(notFoundError)
+	return notFound
+}
+
+// existsError: baseline document kept by Put without overwrite
+type existsError struct {
+	location string
+}
+
+func (e existsError) Error() string {
+	return fmt.Sprintf("baseline already exists: %s", e.location)
+}
+
+// isExists: err reports baseline document kept by Put without overwrite
+func isExists(err error) bool {
+	_, exists := err.(existsError)
+	return exists
+}
+
+// storeTransientCodes: AWS error codes transient for the baseline store only,
+// on top of the retryable codes shared by every AWS call (see isRetryable).
+// A store denying access, as while a new role policy propagates, must fail
+// the evaluation rather than report every resource as drifted without baseline.
+var storeTransientCodes = map[string]bool{
+	"AccessDenied":               true,
+	"AccessDeniedException":      true,
+	"ConditionalRequestConflict": true, // S3: concurrent conditional write, retry gets PreconditionFailed
+}
+
+// transientError: store failure, like throttling or access denied, that
+// may go away on retry. Never reported as drift.
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+// isTransient: err is a transientError
+func isTransient(err error) bool {
+	_, transient := err.(transientError)
+	return transient
+}
+
+// storeError: err from store call at location, marked transient when retryable or denied
+func storeError(location string, err error) error {
+	wrapped := fmt.Errorf("%s: %v", location, err)
+	if isRetryable(err) {
+		return transientError{wrapped}
+	}
+	if awsErr, isAws := err.(awserr.Error); isAws && storeTransientCodes[awsErr.Code()] {
+		return transientError{wrapped}
+	}
+	return wrapped
+}
+
+// Retry of transient store failures
+var (
+	storeAttempts = 3
+	storeBackoff  = 200 * time.Millisecond
+)
+
+// retryStore: call f until it succeeds, fails with non-transient error, or attempts run out.
+// Backoff grows exponentially with jitter and never waits past ctx.
+func retryStore(ctx context.Context, f func() error) error {
+	return retryWith(ctx, opStore, retryPolicy{attempts: storeAttempts, base: storeBackoff, max: 2 * time.Second}, f)
+}
+
+// baselineURI: parsed location of baseline store
+type baselineURI struct {
+	scheme   string
+	location string     // bucket/prefix, directory, parameter path prefix or table
+	query    url.Values // scheme options
+}
+
+func (u baselineURI) String() string {
+	s := u.scheme + "://" + u.location
+	if len(u.query) > 0 {
+		s += "?" + u.query.Encode()
+	}
+	return s
+}
+
+// parseBaselineURI: s3://bucket/prefix, file:///dir, ssm:///prefix, dynamodb://table
+func parseBaselineURI(s string) (baselineURI, error) {
+	var b baselineURI
+
+	u, errParse := url.Parse(s)
+	if errParse != nil {
+		return b, errParse
+	}
+
+	b.scheme = u.Scheme
+	b.location = u.Host + u.Path
+	b.query = u.Query()
+
+	switch b.scheme {
+	case schemeS3:
+		if name, _ := splitBucket(b.location); name == "" {
+			return b, fmt.Errorf("empty bucket name: %s", s)
+		}
+	case schemeFile:
+		if b.location == "" {
+			return b, fmt.Errorf("empty directory: %s", s)
+		}
+	case schemeSSM:
+	case schemeDynamoDB:
+		if u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return b, fmt.Errorf("expected dynamodb://table: %s", s)
+		}
+		for k := range b.query {
+			if k != dynamoQueryKey && k != dynamoQueryDocument {
+				return b, fmt.Errorf("unknown dynamodb option '%s' (expected %s or %s): %s", k, dynamoQueryKey, dynamoQueryDocument, s)
+			}
+		}
+	case "":
+		return b, fmt.Errorf("missing scheme (expected %s://, %s://, %s:// or %s://): %s", schemeS3, schemeFile, schemeSSM, schemeDynamoDB, s)
+	default:
+		return b, fmt.Errorf("unsupported scheme '%s' (expected %s, %s, %s or %s): %s", b.scheme, schemeS3, schemeFile, schemeSSM, schemeDynamoDB, s)
+	}
+
+	if b.scheme != schemeDynamoDB && len(b.query) > 0 {
+		return b, fmt.Errorf("unexpected query: %s", s)
+	}
+
+	return b, nil
+}
+
+// newStore: store for location, using AWS clients when needed
+func newStore(clientConf *Clients, u baselineURI) BaselineStore {
+	switch u.scheme {
+	case schemeFile:
+		return fileStore{dir: u.location}
+	case schemeSSM:
+		return ssmStore{client: clientConf.SSM, prefix: strings.Trim(u.location, "/")}
+	case schemeDynamoDB:
+		s := dynamoStore{client: clientConf.DynamoDB, table: u.location, keyAttribute: dynamoKeyAttribute, docAttribute: dynamoDocAttribute}
+		if k := u.query.Get(dynamoQueryKey); k != "" {
+			s.keyAttribute = k
+		}
+		if a := u.query.Get(dynamoQueryDocument); a != "" {
+			s.docAttribute = a
+		}
+		return s
+	}
+	name, prefix := splitBucket(u.location)
+	return s3Store{client: clientConf.S3, bucket: name, prefix: prefix}
+}
+
+// fetch: baseline document for key from store, retrying transient failures
+func fetch(ctx context.Context, store BaselineStore, key string) (map[string]interface{}, error) {
+
+	var buf []byte
+	errGet := retryStore(ctx, func() error {
+		var err error
+		buf, err = store.Get(ctx, key)
+		return err
+	})
+	if errGet != nil {
+		return nil, errGet
+	}
+
+	target := map[string]interface{}{}
+	if errJson := json.Unmarshal(buf, &target); errJson != nil {
+		return nil, fmt.Errorf("%s: %v", store.Location(key), errJson)
+	}
+
+	return target, nil
+}
+
+// s3Store: baselines as objects bucket/prefix/key.
+// S3 has no conditional write, so Put always overwrites.
+type s3Store struct {
+	client S3API
+	bucket string
+	prefix string
+}
+
+func (s s3Store) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s s3Store) Location(key string) string {
+	return schemeS3 + "://" + s.bucket + "/" + s.objectKey(key)
+}
+
+func (s s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),         // Required
+		Key:    aws.String(s.objectKey(key)), // Required
+	}
+
+	resp, errSend := s.client.GetObject(ctx, params)
+	if errSend != nil {
+		if awsErr, isAws := errSend.(awserr.Error); isAws && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, notFoundError{s.Location(key)}
+		}
+		return nil, storeError(s.Location(key), errSend)
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s s3Store) Put(ctx context.Context, key string, doc []byte, overwrite bool) error {
+
+	params := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(key)),
+		Body:        bytes.NewReader(doc),
+		ContentType: aws.String("application/json"),
+	}
+
+	if overwrite {
+		if _, errPut := s.client.PutObject(ctx, params); errPut != nil {
+			return storeError(s.Location(key), errPut)
+		}
+		return nil
+	}
+
+	if _, errPut := s.client.PutObjectIfAbsent(ctx, params); errPut != nil {
+		if awsErr, isAws := errPut.(awserr.Error); isAws && awsErr.Code() == s3ErrCodePreconditionFailed {
+			return existsError{s.Location(key)}
+		}
+		return storeError(s.Location(key), errPut)
+	}
+
+	return nil
+}
+
+// fileStore: baselines as files dir/key, for tests and local runs
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) path(key string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	rel, errRel := filepath.Rel(s.dir, p)
+	if errRel != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return p, fmt.Errorf("key escapes directory %s: %s", s.dir, key)
+	}
+	return p, nil
+}
+
+func (s fileStore) Location(key string) string {
+	p, _ := s.path(key)
+	return schemeFile + "://" + filepath.ToSlash(p)
+}
+
+func (s fileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	p, errPath := s.path(key)
+	if errPath != nil {
+		return nil, errPath
+	}
+	buf, errRead := ioutil.ReadFile(p)
+	if errRead != nil {
+		if os.IsNotExist(errRead) {
+			return nil, notFoundError{s.Location(key)}
+		}
+		return nil, errRead
+	}
+	return buf, nil
+}
+
+func (s fileStore) Put(ctx context.Context, key string, doc []byte, overwrite bool) error {
+	p, errPath := s.path(key)
+	if errPath != nil {
+		return errPath
+	}
+	if errDir := os.MkdirAll(filepath.Dir(p), 0750); errDir != nil {
+		return errDir
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, errOpen := os.OpenFile(p, flags, 0640)
+	if errOpen != nil {
+		if os.IsExist(errOpen) {
+			return existsError{s.Location(key)}
+		}
+		return errOpen
+	}
+	if _, errWrite := f.Write(doc); errWrite != nil {
+		f.Close()
+		return errWrite
+	}
+	return f.Close()
+}
+
+// SSM parameter tiers: Intelligent-Tiering uses the Standard tier for values
+// up to 4 KB and the Advanced tier, limited to 8 KB, for bigger values.
+// The SDK version in use has no constant for Intelligent-Tiering.
+const (
+	ssmTierIntelligentTiering ssm.ParameterTier = "Intelligent-Tiering"
+	ssmMaxValueSize                             = 8192
+)
+
+// ssmStore: baselines as parameters /prefix/key in SSM Parameter Store
+type ssmStore struct {
+	client SSMAPI
+	prefix string
+}
+
+// name: parameter name for key.
+// Parameter names do not accept ':', so resource types like AWS::EC2::Instance become AWS/EC2/Instance.
+func (s ssmStore) name(key string) string {
+	key = strings.Replace(key, "::", "/", -1)
+	if s.prefix == "" {
+		return "/" + key
+	}
+	return "/" + s.prefix + "/" + key
+}
+
+func (s ssmStore) Location(key string) string {
+	return schemeSSM + "://" + s.name(key)
+}
+
+func (s ssmStore) Get(ctx context.Context, key string) ([]byte, error) {
+
+	params := &ssm.GetParameterInput{
+		Name:           aws.String(s.name(key)),
+		WithDecryption: aws.Bool(true),
+	}
+
+	resp, errGet := s.client.GetParameter(ctx, params)
+	if errGet != nil {
+		if awsErr, isAws := errGet.(awserr.Error); isAws && awsErr.Code() == ssm.ErrCodeParameterNotFound {
+			return nil, notFoundError{s.Location(key)}
+		}
+		return nil, storeError(s.Location(key), errGet)
+	}
+
+	if resp.Parameter == nil || resp.Parameter.Value == nil {
+		return nil, notFoundError{s.Location(key)}
+	}
+
+	return []byte(*resp.Parameter.Value), nil
+}
+
+func (s ssmStore) Put(ctx context.Context, key string, doc []byte, overwrite bool) error {
+
+	if len(doc) > ssmMaxValueSize {
+		return fmt.Errorf("%s: document size %d exceeds SSM parameter limit %d, use another baseline store", s.Location(key), len(doc), ssmMaxValueSize)
+	}
+
+	params := &ssm.PutParameterInput{
+		Name:      aws.String(s.name(key)),
+		Value:     aws.String(string(doc)),
+		Type:      ssm.ParameterTypeString,
+		Tier:      ssmTierIntelligentTiering,
+		Overwrite: aws.Bool(overwrite),
+	}
+
+	if _, errPut := s.client.PutParameter(ctx, params); errPut != nil {
+		if awsErr, isAws := errPut.(awserr.Error); isAws && awsErr.Code() == ssm.ErrCodeParameterAlreadyExists {
+			return existsError{s.Location(key)}
+		}
+		return storeError(s.Location(key), errPut)
+	}
+
+	return nil
+}
+
+// dynamoStore: baselines as items in DynamoDB table.
+// The document is held by attribute docAttribute either as JSON string or as map.
+type dynamoStore struct {
+	client       DynamoDBAPI
+	table        string
+	keyAttribute string
+	docAttribute string
+}
+
+func (s dynamoStore) Location(key string) string {
+	return fmt.Sprintf("%s://%s/%s=%s", schemeDynamoDB, s.table, s.keyAttribute, key)
+}
+
+func (s dynamoStore) Get(ctx context.Context, key string) ([]byte, error) {
+
+	params := &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]dynamodb.AttributeValue{
+			s.keyAttribute: {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	resp, errGet := s.client.GetItem(ctx, params)
+	if errGet != nil {
+		return nil, storeError(s.Location(key), errGet)
+	}
+
+	if len(resp.Item) == 0 {
+		return nil, notFoundError{s.Location(key)}
+	}
+
+	doc, found := resp.Item[s.docAttribute]
+	if !found {
+		return nil, fmt.Errorf("%s: missing attribute %s", s.Location(key), s.docAttribute)
+	}
+
+	if doc.S != nil {
+		return []byte(*doc.S), nil
+	}
+
+	if doc.M == nil {
+		return nil, fmt.Errorf("%s: attribute %s: expected string or map", s.Location(key), s.docAttribute)
+	}
+
+	m := map[string]interface{}{}
+	if errDecode := dynamodbattribute.UnmarshalMap(doc.M, &m); errDecode != nil {
+		return nil, fmt.Errorf("%s: attribute %s: %v", s.Location(key), s.docAttribute, errDecode)
+	}
+
+	return json.Marshal(m)
+}
+
+func (s dynamoStore) Put(ctx context.Context, key string, doc []byte, overwrite bool) error {
+
+	params := &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]dynamodb.AttributeValue{
+			s.keyAttribute: {S: aws.String(key)},
+			s.docAttribute: {S: aws.String(string(doc))},
+		},
+	}
+	if !overwrite {
+		params.ConditionExpression = aws.String("attribute_not_exists(#k)")
+		params.ExpressionAttributeNames = map[string]string{"#k": s.keyAttribute}
+	}
+
+	if _, errPut := s.client.PutItem(ctx, params); errPut != nil {
+		if awsErr, isAws := errPut.(awserr.Error); isAws && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return existsError{s.Location(key)}
+		}
+		return storeError(s.Location(key), errPut)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestStore(t *testing.T) {
+
+	const doc = `{"configuration":{"instanceType":"t2.micro"}}`
+
+	clients := &Clients{
+		S3: &FakeS3{Objects: map[string]string{
+			"baselines/prefix/i-1": doc,
+		}},
+		SSM: &FakeSSM{Parameters: map[string]string{
+			"/baselines/i-1": doc,
+		}},
+		DynamoDB: &FakeDynamoDB{Items: map[string]map[string]dynamodb.AttributeValue{
+			"baselines/i-1": {
+				"id":       {S: aws.String("i-1")},
+				"baseline": {S: aws.String(doc)},
+			},
+			"baselines/i-2": {
+				"id": {S: aws.String("i-2")},
+				"baseline": {M: map[string]dynamodb.AttributeValue{
+					"configuration": {M: map[string]dynamodb.AttributeValue{
+						"instanceType": {S: aws.String("t2.micro")},
+					}},
+				}},
+			},
+		}},
+	}
+
+	tests := []struct {
+		uri      string
+		key      string
+		location string
+		notFound bool
+	}{
+		{uri: "s3://baselines/prefix/", key: "i-1", location: "s3://baselines/prefix/i-1"},
+		{uri: "s3://baselines/prefix", key: "i-9", location: "s3://baselines/prefix/i-9", notFound: true},
+		{uri: "file://testdata/target", key: "i-0aaa1111bbbb2222c", location: "file://testdata/target/i-0aaa1111bbbb2222c"},
+		{uri: "file://testdata/target", key: "i-9", location: "file://testdata/target/i-9", notFound: true},
+		{uri: "ssm:///baselines", key: "i-1", location: "ssm:///baselines/i-1"},
+		{uri: "ssm:///baselines", key: "AWS::EC2::Instance/i-1", location: "ssm:///baselines/AWS/EC2/Instance/i-1", notFound: true},
+		{uri: "ssm://baselines/", key: "i-9", location: "ssm:///baselines/i-9", notFound: true},
+		{uri: "dynamodb://baselines?key=id", key: "i-1", location: "dynamodb://baselines/id=i-1"},
+		{uri: "dynamodb://baselines?key=id", key: "i-2", location: "dynamodb://baselines/id=i-2"},
+		{uri: "dynamodb://baselines", key: "i-9", location: "dynamodb://baselines/key=i-9", notFound: true},
+	}
+
+	for _, test := range tests {
+		u, errURI := parseBaselineURI(test.uri)
+		if errURI != nil {
+			t.Errorf("uri=%s: %v", test.uri, errURI)
+			continue
+		}
+		store := newStore(clients, u)
+		if loc := store.Location(test.key); loc != test.location {
+			t.Errorf("uri=%s key=%s location: expected=%s got=%s", test.uri, test.key, test.location, loc)
+		}
+		target, errFetch := fetch(context.Background(), store, test.key)
+		if isNotFound(errFetch) != test.notFound {
+			t.Errorf("uri=%s key=%s not found: expected=%v got=%v", test.uri, test.key, test.notFound, errFetch)
+			continue
+		}
+		if test.notFound {
+			continue
+		}
+		if errFetch != nil {
+			t.Errorf("uri=%s key=%s: %v", test.uri, test.key, errFetch)
+			continue
+		}
+		if _, found := target["configuration"]; !found {
+			t.Errorf("uri=%s key=%s: missing configuration in target: %v", test.uri, test.key, target)
+		}
+	}
+}
+
+func TestFileStoreEscape(t *testing.T) {
+	store := fileStore{dir: "testdata/target"}
+	if _, err := store.Get(context.Background(), "../item/i-0aaa1111bbbb2222c"); err == nil || isNotFound(err) {
+		t.Errorf("expected error for key escaping directory, got: %v", err)
+	}
+}
+
+func TestStoreTransient(t *testing.T) {
+
+	saveAttempts, saveBackoff := storeAttempts, storeBackoff
+	defer func() { storeAttempts, storeBackoff = saveAttempts, saveBackoff }()
+	storeAttempts, storeBackoff = 3, time.Millisecond
+
+	tests := []struct {
+		code      string
+		transient bool
+		gets      int
+	}{
+		{code: "Throttling", transient: true, gets: 3},
+		{code: "SlowDown", transient: true, gets: 3},
+		{code: "ServiceUnavailable", transient: true, gets: 3},
+		{code: "AccessDenied", transient: true, gets: 3},
+		{code: "NoSuchBucket", transient: false, gets: 1},
+	}
+
+	for _, test := range tests {
+		fake := &FakeS3{GetErr: awserr.New(test.code, "failure", nil)}
+		store := s3Store{client: fake, bucket: "baselines"}
+		_, errFetch := fetch(context.Background(), store, "i-1")
+		if isTransient(errFetch) != test.transient {
+			t.Errorf("code=%s transient: expected=%v got=%v", test.code, test.transient, errFetch)
+		}
+		if fake.Gets != test.gets {
+			t.Errorf("code=%s calls: expected=%d got=%d", test.code, test.gets, fake.Gets)
+		}
+	}
+
+	// transient failure is an evaluation error, never drift
+	clients := &Clients{S3: &FakeS3{GetErr: awserr.New("ThrottlingException", "rate exceeded", nil)}}
+	r := rule{}
+	r.baseline = baselineURI{scheme: schemeS3, location: "baselines"}
+	r.keyTemplate = defaultKeyTemplate
+	item := configurationItem{Status: "OK", ResourceType: "AWS::EC2::Instance", ResourceId: "i-1", Payload: map[string]interface{}{}}
+	sub := newSubmitter(&FakeConfig{}, "token")
+	if _, errEval := evalItem(context.Background(), clients, r, item, time.Now(), sub); errEval == nil {
+		t.Errorf("expected evaluation error on throttling")
+	}
+	if len(sub.pending) != 0 {
+		t.Errorf("expected no evaluation on throttling, got: %v", sub.pending)
+	}
+}
+
+func TestStorePut(t *testing.T) {
+
+	clients := &Clients{S3: &FakeS3{}, SSM: &FakeSSM{}, DynamoDB: &FakeDynamoDB{}}
+
+	dir, errDir := ioutil.TempDir("", "baselines")
+	if errDir != nil {
+		t.Fatalf("temp dir: %v", errDir)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, uri := range []string{"s3://baselines/prefix", "ssm:///baselines", "dynamodb://baselines", "file://" + dir} {
+		u, errURI := parseBaselineURI(uri)
+		if errURI != nil {
+			t.Errorf("uri=%s: %v", uri, errURI)
+			continue
+		}
+		store := newStore(clients, u)
+		if errPut := store.Put(context.Background(), "AWS::EC2::Instance/i-1", []byte(`{"configuration":{}}`), false); errPut != nil {
+			t.Errorf("uri=%s put: %v", uri, errPut)
+			continue
+		}
+		target, errFetch := fetch(context.Background(), store, "AWS::EC2::Instance/i-1")
+		if errFetch != nil {
+			t.Errorf("uri=%s fetch: %v", uri, errFetch)
+			continue
+		}
+		if _, found := target["configuration"]; !found {
+			t.Errorf("uri=%s: missing configuration in target: %v", uri, target)
+		}
+
+		// conditional write keeps existing document
+		if errPut := store.Put(context.Background(), "AWS::EC2::Instance/i-1", []byte(`{}`), false); !isExists(errPut) {
+			t.Errorf("uri=%s put over existing: expected existsError, got: %v", uri, errPut)
+		}
+		if target, _ := fetch(context.Background(), store, "AWS::EC2::Instance/i-1"); target["configuration"] == nil {
+			t.Errorf("uri=%s: existing document replaced: %v", uri, target)
+		}
+		if errPut := store.Put(context.Background(), "AWS::EC2::Instance/i-1", []byte(`{}`), true); errPut != nil {
+			t.Errorf("uri=%s overwrite: %v", uri, errPut)
+		}
+	}
+}
+
+// preconditionTransport: HTTP transport recording request headers, answering 412 Precondition Failed
+type preconditionTransport struct {
+	header http.Header
+}
+
+func (f *preconditionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.header = req.Header
+	body := `<?xml version="1.0" encoding="UTF-8"?><Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`
+	return &http.Response{
+		StatusCode: http.StatusPreconditionFailed,
+		Header:     http.Header{"Content-Type": []string{"application/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestStorePutS3IfAbsent(t *testing.T) {
+	transport := &preconditionTransport{}
+	cfg := defaults.Config()
+	cfg.Region = "sa-east-1"
+	cfg.Credentials = &fakeCredentials{}
+	cfg.HTTPClient = &http.Client{Transport: transport}
+
+	store := s3Store{client: newClients(context.Background(), cfg, targetRegions(cfg, "", "")).S3, bucket: "baselines"}
+
+	errPut := store.Put(context.Background(), "AWS::EC2::Instance/i-1", []byte(`{}`), false)
+	if transport.header.Get("If-None-Match") != "*" {
+		t.Errorf("conditional write without If-None-Match: %v", transport.header)
+	}
+	if !isExists(errPut) {
+		t.Errorf("expected existsError, got: %v", errPut)
+	}
+}
+
+func TestStorePutSSMSize(t *testing.T) {
+
+	fake := &FakeSSM{}
+	store := ssmStore{client: fake, prefix: "baselines"}
+
+	// captured items are often beyond the 4 KB Standard tier limit
+	doc := `{"configuration":"` + strings.Repeat("x", 6000) + `"}`
+	if errPut := store.Put(context.Background(), "i-1", []byte(doc), false); errPut != nil {
+		t.Errorf("put 6 KB document: %v", errPut)
+	}
+
+	doc = `{"configuration":"` + strings.Repeat("x", ssmMaxValueSize) + `"}`
+	errPut := store.Put(context.Background(), "i-2", []byte(doc), false)
+	if errPut == nil || !strings.Contains(errPut.Error(), "exceeds SSM parameter limit") {
+		t.Errorf("put oversized document: expected size error, got: %v", errPut)
+	}
+	if _, found := fake.Parameters["/baselines/i-2"]; found {
+		t.Errorf("oversized document written")
+	}
+}
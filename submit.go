@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+// maxEvaluationsPerCall: PutEvaluations API limit
+const maxEvaluationsPerCall = 100
+
+// submitter: collect evaluations and send them to config service in batches
+type submitter struct {
+	config      ConfigAPI
+	resultToken string
+	pending     []configservice.Evaluation
+	attempts    int           // send attempts for each evaluation
+	backoff     time.Duration // wait before first resubmission, doubled with jitter for each further one
+	maxBackoff  time.Duration
+}
+
+func newSubmitter(config ConfigAPI, resultToken string) *submitter {
+	p := policyFor("PutEvaluations")
+	return &submitter{
+		config:      config,
+		resultToken: resultToken,
+		attempts:    p.attempts,
+		backoff:     p.base,
+		maxBackoff:  p.max,
+	}
+}
+
+func (s *submitter) add(eval configservice.Evaluation) {
+	s.pending = append(s.pending, eval)
+}
+
+// flush: send pending evaluations, resubmitting failed ones with backoff.
+// Evaluations failed by non-retryable errors, like request validation, are not resubmitted.
+// Evaluations still failed after all attempts, or when ctx would expire
+// during backoff, are reported as error.
+func (s *submitter) flush(ctx context.Context) error {
+	log := loggerFrom(ctx).with(logStage, "submit")
+	policy := retryPolicy{attempts: s.attempts, base: s.backoff, max: s.maxBackoff}
+	var lastErr error
+	var rejected []configservice.Evaluation
+
+LOOP:
+	for attempt := 1; attempt <= s.attempts && len(s.pending) > 0; attempt++ {
+		if attempt > 1 {
+			wait := policy.backoff(attempt - 1)
+			if !waitFits(ctx, wait) {
+				lastErr = ctx.Err()
+				if lastErr == nil {
+					lastErr = fmt.Errorf("deadline too close for backoff %v", wait)
+				}
+				break LOOP
+			}
+			log.warn("PutEvaluations: resubmitting failed evaluations", "evaluations", len(s.pending), "backoff", wait, "attempt", attempt, "attempts", s.attempts)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				lastErr = ctx.Err()
+				break LOOP
+			}
+		}
+
+		var failed []configservice.Evaluation
+
+		for _, chunk := range chunkEvaluations(s.pending, maxEvaluationsPerCall) {
+			input := configservice.PutEvaluationsInput{
+				ResultToken: &s.resultToken,
+				Evaluations: chunk,
+			}
+			resp, errPut := s.config.PutEvaluations(ctx, &input)
+			if errPut != nil {
+				log.warn("PutEvaluations", logOutcome, "failed", "evaluations", len(chunk), logError, errPut)
+				lastErr = errPut
+				if !isRetryable(errPut) {
+					rejected = append(rejected, chunk...)
+					continue
+				}
+				failed = append(failed, chunk...)
+				continue
+			}
+			log.info("PutEvaluations", logOutcome, "ok", "evaluations", len(chunk), "failed", len(resp.FailedEvaluations))
+			failed = append(failed, resp.FailedEvaluations...)
+		}
+
+		s.pending = failed
+	}
+
+	leftover := len(s.pending) + len(rejected)
+	s.pending = nil
+
+	if leftover > 0 {
+		if lastErr != nil {
+			return fmt.Errorf("PutEvaluations: %d evaluations not submitted: %v", leftover, lastErr)
+		}
+		return fmt.Errorf("PutEvaluations: %d evaluations not submitted after %d attempts", leftover, s.attempts)
+	}
+
+	return nil
+}
+
+func chunkEvaluations(list []configservice.Evaluation, size int) [][]configservice.Evaluation {
+	var chunks [][]configservice.Evaluation
+	for len(list) > size {
+		chunks = append(chunks, list[:size])
+		list = list[size:]
+	}
+	if len(list) > 0 {
+		chunks = append(chunks, list)
+	}
+	return chunks
+}
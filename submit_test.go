@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/configservice"
+)
+
+func TestSubmitterBatch(t *testing.T) {
+
+	config := &FakeConfig{}
+
+	sub := newSubmitter(config, "token")
+
+	for i := 0; i < 250; i++ {
+		sub.add(configservice.Evaluation{})
+	}
+
+	if err := sub.flush(context.Background()); err != nil {
+		t.Errorf("flush: %v", err)
+	}
+
+	if fmt.Sprint(config.PutCalls) != "[100 100 50]" {
+		t.Errorf("unexpected batches: %v", config.PutCalls)
+	}
+}
+
+func TestSubmitterRetry(t *testing.T) {
+
+	tests := []struct {
+		failures int // calls reporting every evaluation as failed
+		err      bool
+		calls    int
+	}{
+		{failures: 0, err: false, calls: 1},
+		{failures: 2, err: false, calls: 3},
+		{failures: 3, err: true, calls: 3},
+	}
+
+	for _, test := range tests {
+		config := &FakeConfig{FailPuts: test.failures}
+		sub := newSubmitter(config, "token")
+		sub.backoff = time.Millisecond
+		sub.add(configservice.Evaluation{})
+		sub.add(configservice.Evaluation{})
+
+		err := sub.flush(context.Background())
+		if (err != nil) != test.err {
+			t.Errorf("failures=%d errExpected=%v err=%v", test.failures, test.err, err)
+		}
+		if len(config.PutCalls) != test.calls {
+			t.Errorf("failures=%d callsExpected=%d calls=%d", test.failures, test.calls, len(config.PutCalls))
+		}
+	}
+}
+
+func TestSubmitterDeadline(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // deadline already reached
+
+	config := &FakeConfig{FailPuts: 1}
+	sub := newSubmitter(config, "token")
+	sub.backoff = time.Hour
+	sub.add(configservice.Evaluation{})
+
+	if err := sub.flush(ctx); err == nil {
+		t.Errorf("expected error for expired context")
+	}
+	if len(config.PutCalls) != 1 {
+		t.Errorf("callsExpected=1 calls=%d", len(config.PutCalls))
+	}
+}